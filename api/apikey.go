@@ -0,0 +1,194 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/api/mid"
+	"github.com/94peter/sterna/apikey"
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/model/mgom"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NewApiKeyAPI manages the api keys of the login user, admin can manage
+// the keys of every owner.
+func NewApiKeyAPI(service string) GinAPI {
+	return &apiKeyAPI{
+		ErrorOutputAPI: NewErrorOutputAPI(service),
+	}
+}
+
+type apiKeyAPI struct {
+	ErrorOutputAPI
+}
+
+func (a *apiKeyAPI) GetName() string {
+	return "apikey"
+}
+
+func (a *apiKeyAPI) GetAPIs() []*GinApiHandler {
+	return []*GinApiHandler{
+		{Method: "GET", Path: "/apikey", Handler: a.listHandler, Auth: true},
+		{Method: "POST", Path: "/apikey", Handler: a.createHandler, Auth: true, Sensitive: true},
+		{Method: "PUT", Path: "/apikey/:id/rotate", Handler: a.rotateHandler, Auth: true, Sensitive: true},
+		{Method: "DELETE", Path: "/apikey/:id", Handler: a.revokeHandler, Auth: true, Sensitive: true},
+	}
+}
+
+func (a *apiKeyAPI) getService(c *gin.Context) (apikey.Service, error) {
+	dbclt := db.GetMgoDBClientByGin(c)
+	if dbclt == nil {
+		return nil, apiErr.New(http.StatusInternalServerError, "db not set")
+	}
+	l := log.GetLogByGin(c)
+	return apikey.NewService(
+		mgom.NewMgoModel(c.Request.Context(), dbclt.GetCoreDB(), l), l,
+	), nil
+}
+
+func isAdmin(u auth.ReqUser) bool {
+	return auth.DefaultPolicy.HasRole(u.GetPerm(), auth.PermAdmin)
+}
+
+// getOwnKey returns the key if the user is its owner or admin.
+func (a *apiKeyAPI) getOwnKey(c *gin.Context, serv apikey.Service, u auth.ReqUser) (*apikey.ApiKey, error) {
+	k, err := serv.Get(c.Param("id"))
+	if err == mongo.ErrNoDocuments {
+		return nil, apiErr.New(http.StatusNotFound, "api key not found")
+	}
+	if err != nil {
+		return nil, apiErr.New(http.StatusBadRequest, err.Error())
+	}
+	if k.Owner != u.GetAccount() && !isAdmin(u) {
+		return nil, apiErr.New(http.StatusForbidden, "not the owner of api key")
+	}
+	return k, nil
+}
+
+func (a *apiKeyAPI) listHandler(c *gin.Context) {
+	u := auth.GetUserByGin(c)
+	serv, err := a.getService(c)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	owner := u.GetAccount()
+	if isAdmin(u) {
+		owner = c.Query("owner")
+	}
+	keys, err := serv.List(owner)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+type createApiKeyInput struct {
+	Name    string   `json:"name" binding:"required"`
+	Scopes  []string `json:"scopes"`
+	ExpDays int      `json:"expDays"`
+}
+
+type apiKeyOutput struct {
+	Key string `json:"key"`
+	*apikey.ApiKey
+}
+
+// denyDelegated keeps impersonated sessions and api keys from minting keys
+// that outlive the impersonation token or the revocation of the key.
+func (a *apiKeyAPI) denyDelegated(c *gin.Context, u auth.ReqUser) bool {
+	switch {
+	case auth.IsImpersonated(u):
+		a.GinOutputErr(c, apiErr.NewWithKey(http.StatusForbidden,
+			auth.ErrImpersonateDenied.Error(), mid.ErrKeyImpersonateDenied))
+	case mid.IsApiKeyRequest(c):
+		a.GinOutputErr(c, apiErr.NewWithKey(http.StatusForbidden,
+			apikey.ErrKeyDenied.Error(), mid.ErrKeyApiKeyDenied))
+	default:
+		return false
+	}
+	return true
+}
+
+func (a *apiKeyAPI) createHandler(c *gin.Context) {
+	u := auth.GetUserByGin(c)
+	if a.denyDelegated(c, u) {
+		return
+	}
+	in := createApiKeyInput{}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		a.GinOutputErr(c, apiErr.New(http.StatusBadRequest, err.Error()))
+		return
+	}
+	// 不可建立超出自身權限的 scope
+	for _, s := range in.Scopes {
+		if !auth.DefaultPolicy.Allow(u.GetPerm(), []auth.UserPerm{auth.UserPerm(s)}) {
+			a.GinOutputErr(c, apiErr.New(http.StatusForbidden, "scope not allowed: "+s))
+			return
+		}
+	}
+	serv, err := a.getService(c)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	key, k, err := serv.Create(u.GetAccount(), in.Name, in.Scopes,
+		time.Duration(in.ExpDays)*24*time.Hour, u)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, apiKeyOutput{Key: key, ApiKey: k})
+}
+
+func (a *apiKeyAPI) rotateHandler(c *gin.Context) {
+	u := auth.GetUserByGin(c)
+	if a.denyDelegated(c, u) {
+		return
+	}
+	serv, err := a.getService(c)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	k, err := a.getOwnKey(c, serv, u)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	key, k, err := serv.Rotate(k.ID.Hex(), u)
+	if err == apikey.ErrKeyRevoked {
+		a.GinOutputErr(c, apiErr.New(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiKeyOutput{Key: key, ApiKey: k})
+}
+
+func (a *apiKeyAPI) revokeHandler(c *gin.Context) {
+	u := auth.GetUserByGin(c)
+	serv, err := a.getService(c)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	k, err := a.getOwnKey(c, serv, u)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	if err = serv.Revoke(k.ID.Hex(), u); err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/94peter/sterna/api/mid"
+	"github.com/94peter/sterna/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ApiKeyDenyApiKeyRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(string(auth.CtxUserInfoKey), auth.NewReqUser("test", "1", "user", "user", nil))
+		c.Set(string(mid.CtxApiKeyIDKey), "1")
+	})
+	for _, h := range NewApiKeyAPI("test").GetAPIs() {
+		r.Handle(h.Method, h.Path, h.Handler)
+	}
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/apikey", strings.NewReader(`{"name":"a"}`)),
+		httptest.NewRequest("PUT", "/apikey/1/rotate", nil),
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), mid.ErrKeyApiKeyDenied)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/model/mgom"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewAuditAPI lets admins read the change history of a document written
+// by models with EnableAudit on. The logs are kept in the db of the model,
+// the source query reads the user db with "user", default "core".
+func NewAuditAPI(service string) GinAPI {
+	return &auditAPI{
+		ErrorOutputAPI: NewErrorOutputAPI(service),
+	}
+}
+
+type auditAPI struct {
+	ErrorOutputAPI
+}
+
+func (a *auditAPI) GetName() string {
+	return "audit"
+}
+
+func (a *auditAPI) GetAPIs() []*GinApiHandler {
+	admin := []auth.UserPerm{auth.PermAdmin}
+	return []*GinApiHandler{
+		{Method: "GET", Path: "/audit/:collection/:id", Handler: a.historyHandler, Auth: true, Group: admin},
+	}
+}
+
+type auditCollection string
+
+func (c auditCollection) GetC() string {
+	return string(c)
+}
+
+func (a *auditAPI) historyHandler(c *gin.Context) {
+	dbclt := db.GetMgoDBClientByGin(c)
+	if dbclt == nil {
+		a.GinOutputErr(c, apiErr.New(http.StatusInternalServerError, "db not set"))
+		return
+	}
+	limit, _ := strconv.ParseInt(c.Query("limit"), 10, 64)
+	page, _ := strconv.ParseInt(c.Query("page"), 10, 64)
+	// id 可能是 ObjectID 的 hex 字串
+	var id interface{} = c.Param("id")
+	if oid, err := primitive.ObjectIDFromHex(c.Param("id")); err == nil {
+		id = oid
+	}
+	database := dbclt.GetCoreDB()
+	switch c.DefaultQuery("source", db.CoreDB) {
+	case db.CoreDB:
+	case db.UserDB:
+		if database = dbclt.GetUserDB(); database == nil {
+			a.GinOutputErr(c, apiErr.New(http.StatusBadRequest, "user db not set"))
+			return
+		}
+	default:
+		a.GinOutputErr(c, apiErr.New(http.StatusBadRequest, "invalid source"))
+		return
+	}
+	model := mgom.NewMgoModel(c.Request.Context(), database, log.GetLogByGin(c))
+	logs, err := model.GetHistory(auditCollection(c.Param("collection")), id, limit, page)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, logs)
+}
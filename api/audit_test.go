@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/model/mgom"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type auditDoc struct {
+	dao.CommonDoc `bson:",inline"`
+	ID            string `bson:"_id"`
+	Name          string `bson:"name"`
+}
+
+func (d *auditDoc) GetC() string                   { return "audit_doc" }
+func (d *auditDoc) GetDoc() interface{}            { return d }
+func (d *auditDoc) GetID() interface{}             { return d.ID }
+func (d *auditDoc) SetCreator(u dao.LogUser)       {}
+func (d *auditDoc) GetIndexes() []mongo.IndexModel { return nil }
+
+// Test_AuditHistory needs a mongo server, e.g.
+// TEST_MONGO_URI=mongodb://localhost:27017 go test ./api
+func Test_AuditHistory(t *testing.T) {
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI not set")
+	}
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	conf := &db.MongoConf{Uri: uri, DefaultDB: "sterna_test_core"}
+	clt, err := conf.NewMongoDBClient(ctx, "sterna_test_user")
+	assert.Nil(t, err)
+	defer clt.GetCoreDB().Drop(ctx)
+	defer clt.GetUserDB().Drop(ctx)
+	l := (&log.LoggerConf{}).NewLogger("test")
+
+	// 寫入 user db 的 model
+	model := mgom.NewMgoModel(ctx, clt.GetUserDB(), l)
+	model.EnableAudit(true)
+	d := &auditDoc{ID: "1", Name: "a"}
+	_, err = model.Save(d, nil)
+	assert.Nil(t, err)
+	_, err = model.UpdateOne(d, bson.D{{Key: "name", Value: "b"}}, nil)
+	assert.Nil(t, err)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(string(db.CtxMongoKey), clt)
+		c.Set(string(log.CtxLogKey), l)
+	})
+	for _, h := range NewAuditAPI("test").GetAPIs() {
+		r.Handle(h.Method, h.Path, h.Handler)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/audit/audit_doc/1?source=user", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var logs []*mgom.AuditLog
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &logs))
+	if assert.Len(t, logs, 2) {
+		assert.Equal(t, mgom.AuditUpdate, logs[0].Action)
+		assert.Equal(t, mgom.AuditCreate, logs[1].Action)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/94peter/sterna/api/mid"
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/event"
+	"github.com/gin-gonic/gin"
+)
+
+// NewDiAdminAPI lets admins drop the cached service DI after the config
+// changed. When evt is not nil the invalidation is also fired to
+// mid.DiInvalidateTopic for the other instances.
+func NewDiAdminAPI(service string, registry mid.DiRegistry, evt event.Event) GinAPI {
+	return &diAdminAPI{
+		ErrorOutputAPI: NewErrorOutputAPI(service),
+		registry:       registry,
+		evt:            evt,
+	}
+}
+
+type diAdminAPI struct {
+	ErrorOutputAPI
+	registry mid.DiRegistry
+	evt      event.Event
+}
+
+func (a *diAdminAPI) GetName() string {
+	return "diAdmin"
+}
+
+func (a *diAdminAPI) GetAPIs() []*GinApiHandler {
+	admin := []auth.UserPerm{auth.PermAdmin}
+	return []*GinApiHandler{
+		{Method: "DELETE", Path: "/admin/di", Handler: a.invalidateHandler, Auth: true, Group: admin},
+		{Method: "DELETE", Path: "/admin/di/:service", Handler: a.invalidateHandler, Auth: true, Group: admin},
+	}
+}
+
+func (a *diAdminAPI) invalidateHandler(c *gin.Context) {
+	service := c.Param("service")
+	if service == "" {
+		a.registry.InvalidateAll()
+	} else {
+		a.registry.Invalidate(service)
+	}
+	if a.evt != nil {
+		if err := a.evt.Fire(mid.DiInvalidateTopic, [][]byte{[]byte(service)}); err != nil {
+			a.GinOutputErr(c, err)
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
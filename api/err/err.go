@@ -2,6 +2,7 @@ package err
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 )
@@ -47,7 +48,9 @@ func OutputErr(w http.ResponseWriter, err error) {
 	if err == nil {
 		return
 	}
-	if apiErr, ok := err.(ApiError); ok {
+	// 包裝過的錯誤也輸出原本的狀態碼
+	var apiErr ApiError
+	if errors.As(err, &apiErr) {
 		outJson(w, apiErr.GetStatus(),
 			map[string]interface{}{
 				"status":   apiErr.GetStatus(),
@@ -1,6 +1,7 @@
 package err
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -10,7 +11,9 @@ func GinOutputErr(c *gin.Context, service string, err error) {
 	if err == nil {
 		return
 	}
-	if apiErr, ok := err.(ApiError); ok {
+	// 包裝過的錯誤也輸出原本的狀態碼
+	var apiErr ApiError
+	if errors.As(err, &apiErr) {
 		c.AbortWithStatusJSON(apiErr.GetStatus(),
 			map[string]interface{}{
 				"status":   apiErr.GetStatus(),
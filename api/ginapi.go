@@ -1,6 +1,9 @@
 package api
 
 import (
+	"net/http"
+	"time"
+
 	apiErr "github.com/94peter/sterna/api/err"
 	"github.com/94peter/sterna/api/mid"
 	"github.com/94peter/sterna/auth"
@@ -24,7 +27,15 @@ type GinApiHandler struct {
 	Path    string
 	Handler func(c *gin.Context)
 	Auth    bool
-	Group   []auth.UserPerm
+	// Group lists the roles or resource:action permissions allowed to call
+	// the route, a role also admits the roles above it, e.g. PermEditor
+	// admits owner and admin, auth.Perm("invoice", "write") admits users
+	// granted invoice:write through auth.DefaultPolicy.
+	Group []auth.UserPerm
+	// StepUp requires the token user to pass MFA within the duration.
+	StepUp time.Duration
+	// Sensitive routes are denied to impersonated sessions.
+	Sensitive bool
 }
 
 type GinApiServer interface {
@@ -34,6 +45,8 @@ type GinApiServer interface {
 	SetTrustedProxies([]string) GinApiServer
 	Static(relativePath, root string) GinApiServer
 	Run(port string) error
+	// Handler is used to serve the api by app.NewHttpServer.
+	Handler() http.Handler
 }
 
 type apiService struct {
@@ -65,15 +78,23 @@ func (serv *apiService) AddAPIs(apis ...GinAPI) GinApiServer {
 			if serv.authMid != nil {
 				serv.authMid.AddAuthPath(h.Path, h.Method, h.Auth, h.Group)
 			}
+			var handlers []gin.HandlerFunc
+			if h.Sensitive {
+				handlers = append(handlers, mid.DenyImpersonation(api.GetName()))
+			}
+			if h.StepUp > 0 {
+				handlers = append(handlers, mid.RequireStepUp(api.GetName(), h.StepUp))
+			}
+			handlers = append(handlers, h.Handler)
 			switch h.Method {
 			case "GET":
-				serv.Engine.GET(h.Path, h.Handler)
+				serv.Engine.GET(h.Path, handlers...)
 			case "POST":
-				serv.Engine.POST(h.Path, h.Handler)
+				serv.Engine.POST(h.Path, handlers...)
 			case "PUT":
-				serv.Engine.PUT(h.Path, h.Handler)
+				serv.Engine.PUT(h.Path, handlers...)
 			case "DELETE":
-				serv.Engine.DELETE(h.Path, h.Handler)
+				serv.Engine.DELETE(h.Path, handlers...)
 			}
 		}
 	}
@@ -90,6 +111,10 @@ func (serv *apiService) SetTrustedProxies(proxies []string) GinApiServer {
 	return serv
 }
 
+func (serv *apiService) Handler() http.Handler {
+	return serv.Engine
+}
+
 func (serv *apiService) Run(port string) error {
 	return serv.Engine.Run(":" + port)
 }
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NewImpersonateAPI lets admins get a token acting as another user, the
+// service DI is the same as NewLoginAPI.
+func NewImpersonateAPI(service string) GinAPI {
+	return &impersonateAPI{
+		ErrorOutputAPI: NewErrorOutputAPI(service),
+	}
+}
+
+type impersonateAPI struct {
+	ErrorOutputAPI
+}
+
+func (a *impersonateAPI) GetName() string {
+	return "impersonate"
+}
+
+func (a *impersonateAPI) GetAPIs() []*GinApiHandler {
+	return []*GinApiHandler{
+		{Method: "POST", Path: "/impersonate", Handler: a.impersonateHandler, Auth: true,
+			Group: []auth.UserPerm{auth.PermAdmin}, Sensitive: true},
+	}
+}
+
+type impersonateInput struct {
+	Account string `json:"account" binding:"required"`
+	// 代理原因，寫入 log 供稽核
+	Reason string `json:"reason" binding:"required"`
+}
+
+func (a *impersonateAPI) impersonateHandler(c *gin.Context) {
+	u := auth.GetUserByGin(c)
+	in := impersonateInput{}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		a.GinOutputErr(c, apiErr.New(http.StatusBadRequest, err.Error()))
+		return
+	}
+	serv, redis, err := getLoginService(c)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	defer redis.Close()
+	result, err := serv.Impersonate(util.GetHost(c.Request), in.Account, u)
+	switch err {
+	case nil:
+	case auth.ErrImpersonateDenied:
+		a.GinOutputErr(c, apiErr.New(http.StatusForbidden, err.Error()))
+		return
+	case mongo.ErrNoDocuments:
+		a.GinOutputErr(c, apiErr.New(http.StatusNotFound, "account not found"))
+		return
+	default:
+		a.GinOutputErr(c, err)
+		return
+	}
+	if l := log.GetLogByGin(c); l != nil {
+		l.Info(fmt.Sprintf("impersonate: %s as %s, reason: %s", u.GetAccount(), in.Account, in.Reason))
+	}
+	c.JSON(http.StatusOK, result)
+}
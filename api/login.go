@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/94peter/sterna"
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/model/mgom"
+	"github.com/94peter/sterna/util"
+	"github.com/gin-gonic/gin"
+)
+
+const loginRedisDB = "login"
+
+type loginDI interface {
+	auth.LoginDI
+	auth.JwtDI
+	db.RedisDI
+}
+
+// NewLoginAPI provides password login and password change, the service DI
+// must implement auth.LoginDI, auth.JwtDI and db.RedisDI.
+func NewLoginAPI(service string) GinAPI {
+	return &loginAPI{
+		ErrorOutputAPI: NewErrorOutputAPI(service),
+	}
+}
+
+type loginAPI struct {
+	ErrorOutputAPI
+}
+
+func (a *loginAPI) GetName() string {
+	return "login"
+}
+
+func (a *loginAPI) GetAPIs() []*GinApiHandler {
+	return []*GinApiHandler{
+		{Method: "POST", Path: "/login", Handler: a.loginHandler, Auth: false},
+		{Method: "PUT", Path: "/password", Handler: a.changePwdHandler, Auth: true},
+	}
+}
+
+// getLoginService 回傳 LoginService 及用完需關閉的 redis client
+func getLoginService(c *gin.Context) (auth.LoginService, db.RedisClient, error) {
+	servDi, _ := c.Get(string(sterna.CtxServDiKey))
+	di, ok := servDi.(loginDI)
+	if !ok {
+		return nil, nil, apiErr.New(http.StatusInternalServerError, "login di not set")
+	}
+	dbclt := db.GetMgoDBClientByGin(c)
+	if dbclt == nil {
+		return nil, nil, apiErr.New(http.StatusInternalServerError, "db not set")
+	}
+	redis, err := di.NewRedisClientDB(c.Request.Context(), di.GetDB(loginRedisDB))
+	if err != nil {
+		return nil, nil, err
+	}
+	return di.NewLoginService(
+		mgom.NewMgoModel(c.Request.Context(), dbclt.GetCoreDB(), log.GetLogByGin(c)),
+		redis,
+		di.NewJwt(),
+	), redis, nil
+}
+
+func loginErr(err error) error {
+	switch err {
+	case auth.ErrInvalidCredential:
+		return apiErr.New(http.StatusUnauthorized, err.Error())
+	case auth.ErrAccountLocked:
+		return apiErr.New(http.StatusLocked, err.Error())
+	case auth.ErrPasswordReused:
+		return apiErr.New(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, auth.ErrPasswordWeak) {
+		return apiErr.New(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+type loginInput struct {
+	Account  string `json:"account" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func (a *loginAPI) loginHandler(c *gin.Context) {
+	in := loginInput{}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		a.GinOutputErr(c, apiErr.New(http.StatusBadRequest, err.Error()))
+		return
+	}
+	serv, redis, err := getLoginService(c)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	defer redis.Close()
+	result, err := serv.Login(util.GetHost(c.Request), in.Account, in.Password)
+	if err != nil {
+		a.GinOutputErr(c, loginErr(err))
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+type changePwdInput struct {
+	OldPassword string `json:"oldPassword" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
+func (a *loginAPI) changePwdHandler(c *gin.Context) {
+	u := auth.GetUserByGin(c)
+	in := changePwdInput{}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		a.GinOutputErr(c, apiErr.New(http.StatusBadRequest, err.Error()))
+		return
+	}
+	serv, redis, err := getLoginService(c)
+	if err != nil {
+		a.GinOutputErr(c, err)
+		return
+	}
+	defer redis.Close()
+	err = serv.ChangePassword(u.GetAccount(), in.OldPassword, in.NewPassword, u)
+	if err == auth.ErrInvalidCredential {
+		// 已登入狀態下舊密碼錯誤不應回 401
+		a.GinOutputErr(c, apiErr.New(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if err != nil {
+		a.GinOutputErr(c, loginErr(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
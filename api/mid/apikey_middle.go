@@ -0,0 +1,79 @@
+package mid
+
+import (
+	"net/http"
+
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/apikey"
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/model/mgom"
+	"github.com/94peter/sterna/util"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ApiKeyHeaderKey = "X-API-Key"
+	CtxApiKeyIDKey  = util.CtxKey("ctxApiKeyID")
+
+	ErrKeyApiKeyDenied = "apikey_denied"
+)
+
+// IsApiKeyRequest reports whether the request is authenticated by an api key.
+func IsApiKeyRequest(c *gin.Context) bool {
+	_, ok := c.Get(string(CtxApiKeyIDKey))
+	return ok
+}
+
+// NewGinApiKeyMid authenticates the X-API-Key header and installs a
+// auth.ReqUser carrying the key scopes, requests without the header pass
+// through. It must be used after the db middleware.
+func NewGinApiKeyMid(service string) GinMiddle {
+	return &apiKeyMiddle{
+		service: service,
+	}
+}
+
+type apiKeyMiddle struct {
+	service string
+}
+
+func (lm *apiKeyMiddle) GetName() string {
+	return "apikey"
+}
+
+func (lm *apiKeyMiddle) outputErr(c *gin.Context, err error) {
+	apiErr.GinOutputErr(c, lm.service, err)
+}
+
+func (m *apiKeyMiddle) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(ApiKeyHeaderKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+		dbclt := db.GetMgoDBClientByGin(c)
+		if dbclt == nil {
+			m.outputErr(c, apiErr.New(http.StatusInternalServerError, "db not set"))
+			return
+		}
+		l := log.GetLogByGin(c)
+		model := mgom.NewMgoModel(c.Request.Context(), dbclt.GetCoreDB(), l)
+		k, err := apikey.NewService(model, l).Authenticate(key)
+		switch err {
+		case nil:
+		case apikey.ErrInvalidKey, apikey.ErrKeyExpired, apikey.ErrKeyRevoked:
+			m.outputErr(c, apiErr.New(http.StatusUnauthorized, err.Error()))
+			return
+		default:
+			m.outputErr(c, apiErr.New(http.StatusInternalServerError, err.Error()))
+			return
+		}
+		c.Set(string(auth.CtxUserInfoKey), auth.NewReqUser(
+			util.GetHost(c.Request), k.ID.Hex(), k.Owner, k.Name, k.Scopes))
+		c.Set(string(CtxApiKeyIDKey), k.ID.Hex())
+		c.Next()
+	}
+}
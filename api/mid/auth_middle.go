@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/94peter/sterna/auth"
 	"github.com/94peter/sterna/log"
@@ -16,10 +17,9 @@ import (
 
 func NewAuthMid(token auth.JwtToken, kid string) AuthMidInter {
 	return &authMiddle{
-		token:    token,
-		kid:      kid,
-		authMap:  make(map[string]uint8),
-		groupMap: make(map[string][]auth.UserPerm),
+		token:     token,
+		kid:       kid,
+		authPaths: newAuthPaths(),
 	}
 }
 
@@ -28,11 +28,10 @@ func (lm *authMiddle) GetName() string {
 }
 
 type authMiddle struct {
-	token    auth.JwtToken
-	kid      string
-	log      log.Logger
-	authMap  map[string]uint8
-	groupMap map[string][]auth.UserPerm
+	token auth.JwtToken
+	kid   string
+	log   log.Logger
+	authPaths
 }
 
 const (
@@ -50,39 +49,6 @@ func getPathKey(path, method string) string {
 	return fmt.Sprintf("%s:%s", path, method)
 }
 
-func (am *authMiddle) AddAuthPath(path string, method string, isAuth bool, group []auth.UserPerm) {
-	value := uint8(0)
-	if isAuth {
-		value = value | authValue
-	}
-	key := getPathKey(path, method)
-	am.authMap[key] = uint8(value)
-	am.groupMap[key] = group
-}
-
-func (am *authMiddle) IsAuth(path string, method string) bool {
-	key := getPathKey(path, method)
-	value, ok := am.authMap[key]
-	if ok {
-		return (value & authValue) > 0
-	}
-	return false
-}
-
-func (am *authMiddle) HasGroup(path, method string, group string) bool {
-	key := fmt.Sprintf("%s:%s", path, method)
-	groupAry, ok := am.groupMap[key]
-	if !ok || groupAry == nil || len(groupAry) == 0 {
-		return true
-	}
-	for _, g := range groupAry {
-		if string(g) == group {
-			return true
-		}
-	}
-	return false
-}
-
 func (am *authMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerFunc {
 	return func(f http.HandlerFunc) http.HandlerFunc {
 		// one time scope setup area for middleware
@@ -123,7 +89,7 @@ func (am *authMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerFunc
 					return
 				}
 				permission, ok := mapClaims["per"].(string)
-				if hasPerm := am.HasGroup(path, r.Method, permission); ok && !hasPerm {
+				if hasPerm := am.HasPerm(path, r.Method, []string{permission}); ok && !hasPerm {
 					w.WriteHeader(http.StatusUnauthorized)
 					w.Write([]byte("permission error"))
 					return
@@ -139,11 +105,16 @@ func (am *authMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerFunc
 						mapClaims["nam"].(string),
 						[]string{permission},
 					)
+					if mfaAt, ok := mapClaims[auth.ClaimMfa].(float64); ok {
+						reqUser = auth.WithMfa(reqUser, time.Unix(int64(mfaAt), 0))
+					}
+					reqUser = auth.Impersonate(reqUser, auth.NewActorByClaim(iss, mapClaims[auth.ClaimActor]))
 					r = util.SetCtxKeyVal(r, auth.CtxUserInfoKey, reqUser)
 				} else if usage == "access" {
-					source := mapClaims["source"].(string)
-					id := mapClaims["sourceId"].(string)
-					if !strings.Contains(r.RequestURI, util.StrAppend(source, "/", id)) {
+					// Deprecated: access token 不會過期，請改用 auth.UsageLink
+					source, _ := mapClaims["source"].(string)
+					id, _ := mapClaims["sourceId"].(string)
+					if !matchAccessPath(r.URL.Path, source, id) {
 						w.WriteHeader(http.StatusForbidden)
 						w.Write([]byte("token permision invalid"))
 						return
@@ -169,6 +140,11 @@ func (am *authMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerFunc
 						[]string{permission},
 					)
 					r = util.SetCtxKeyVal(r, auth.CtxUserInfoKey, reqUser)
+				} else {
+					// link token 需經由 link middleware 驗證使用範圍
+					w.WriteHeader(http.StatusUnauthorized)
+					w.Write([]byte(fmt.Sprintf("token usage not supported: %v", usage)))
+					return
 				}
 			} else {
 				ip, _, _ := net.SplitHostPort(r.RemoteAddr)
@@ -179,3 +155,26 @@ func (am *authMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerFunc
 		}
 	}
 }
+
+// matchAccessPath reports whether path contains source/id as whole path
+// segments, /doc/1 matches /api/doc/1/file but not /api/doc/10.
+func matchAccessPath(path, source, id string) bool {
+	if source == "" || id == "" {
+		return false
+	}
+	target := strings.Split(strings.Trim(util.StrAppend(source, "/", id), "/"), "/")
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i+len(target) <= len(segs); i++ {
+		match := true
+		for j, t := range target {
+			if segs[i+j] != t {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
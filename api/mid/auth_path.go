@@ -0,0 +1,48 @@
+package mid
+
+import (
+	"github.com/94peter/sterna/auth"
+)
+
+// authPaths keeps the auth setting of the registered routes, it is shared by
+// the auth middlewares so they enforce the same rules.
+type authPaths struct {
+	authMap  map[string]uint8
+	groupMap map[string][]auth.UserPerm
+}
+
+func newAuthPaths() authPaths {
+	return authPaths{
+		authMap:  make(map[string]uint8),
+		groupMap: make(map[string][]auth.UserPerm),
+	}
+}
+
+func (am *authPaths) AddAuthPath(path string, method string, isAuth bool, group []auth.UserPerm) {
+	value := uint8(0)
+	if isAuth {
+		value = value | authValue
+	}
+	key := getPathKey(path, method)
+	am.authMap[key] = uint8(value)
+	am.groupMap[key] = group
+}
+
+func (am *authPaths) IsAuth(path string, method string) bool {
+	key := getPathKey(path, method)
+	value, ok := am.authMap[key]
+	if ok {
+		return (value & authValue) > 0
+	}
+	return false
+}
+
+// HasPerm checks perm against the groups of the route with
+// auth.DefaultPolicy, routes without groups allow everyone.
+func (am *authPaths) HasPerm(path, method string, perm []string) bool {
+	groupAry, ok := am.groupMap[getPathKey(path, method)]
+	if !ok {
+		return true
+	}
+	return auth.DefaultPolicy.Allow(perm, groupAry)
+}
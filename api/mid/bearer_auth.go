@@ -27,8 +27,7 @@ type AuthTokenParser func(token string) (TokenParserResult, error)
 func NewBearerAuthMid(tokenParser AuthTokenParser, isMatchHost bool) AuthMidInter {
 	return &bearAuthMiddle{
 		parser:      tokenParser,
-		authMap:     make(map[string]uint8),
-		groupMap:    make(map[string][]auth.UserPerm),
+		authPaths:   newAuthPaths(),
 		isMatchHost: isMatchHost,
 	}
 }
@@ -36,8 +35,7 @@ func NewBearerAuthMid(tokenParser AuthTokenParser, isMatchHost bool) AuthMidInte
 func NewGinBearAuthMid(service string, isMatchHost bool) AuthGinMidInter {
 	return &bearAuthMiddle{
 		service:     service,
-		authMap:     make(map[string]uint8),
-		groupMap:    make(map[string][]auth.UserPerm),
+		authPaths:   newAuthPaths(),
 		isMatchHost: isMatchHost,
 	}
 }
@@ -50,9 +48,8 @@ type bearAuthMiddle struct {
 	service     string
 	parser      AuthTokenParser
 	log         log.Logger
-	authMap     map[string]uint8
-	groupMap    map[string][]auth.UserPerm
 	isMatchHost bool
+	authPaths
 }
 
 const (
@@ -63,39 +60,6 @@ func (lm *bearAuthMiddle) outputErr(c *gin.Context, err error) {
 	apiErr.GinOutputErr(c, lm.service, err)
 }
 
-func (am *bearAuthMiddle) AddAuthPath(path string, method string, isAuth bool, group []auth.UserPerm) {
-	value := uint8(0)
-	if isAuth {
-		value = value | authValue
-	}
-	key := getPathKey(path, method)
-	am.authMap[key] = uint8(value)
-	am.groupMap[key] = group
-}
-
-func (am *bearAuthMiddle) IsAuth(path string, method string) bool {
-	key := getPathKey(path, method)
-	value, ok := am.authMap[key]
-	if ok {
-		return (value & authValue) > 0
-	}
-	return false
-}
-
-func (am *bearAuthMiddle) HasPerm(path, method string, perm []string) bool {
-	key := fmt.Sprintf("%s:%s", path, method)
-	groupAry, ok := am.groupMap[key]
-	if !ok || groupAry == nil || len(groupAry) == 0 {
-		return true
-	}
-	for _, g := range groupAry {
-		if util.IsStrInList(string(g), perm...) {
-			return true
-		}
-	}
-	return false
-}
-
 func (am *bearAuthMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerFunc {
 	return func(f http.HandlerFunc) http.HandlerFunc {
 		// one time scope setup area for middleware
@@ -136,13 +100,7 @@ func (am *bearAuthMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerF
 					w.Write([]byte("permission error"))
 					return
 				}
-				r = util.SetCtxKeyVal(r, auth.CtxUserInfoKey, auth.NewReqUser(
-					result.Host(),
-					result.Sub(),
-					result.Account(),
-					result.Name(),
-					result.Perms(),
-				))
+				r = util.SetCtxKeyVal(r, auth.CtxUserInfoKey, newReqUserByResult(result))
 			}
 			f(w, r)
 		}
@@ -158,24 +116,21 @@ func (m *bearAuthMiddle) Handler() gin.HandlerFunc {
 			return
 		}
 		if m.IsAuth(path, method) {
-			authToken := c.GetHeader(BearerAuthTokenKey)
-			if authToken == "" {
-				m.outputErr(c, apiErr.New(http.StatusUnauthorized, "miss token"))
-				return
-			}
-
-			if !strings.HasPrefix(authToken, "Bearer ") {
-				m.outputErr(c, apiErr.New(http.StatusUnauthorized, "invalid token: missing Bearer"))
-				return
-			}
-
-			u, ok := c.Get(string(auth.CtxUserInfoKey))
-			if !ok {
+			// 使用者可能已由 token 或 api key middleware 設定
+			reqUser := auth.GetUserByGin(c)
+			if reqUser == nil {
+				authToken := c.GetHeader(BearerAuthTokenKey)
+				if authToken == "" {
+					m.outputErr(c, apiErr.New(http.StatusUnauthorized, "miss token"))
+					return
+				}
+				if !strings.HasPrefix(authToken, "Bearer ") {
+					m.outputErr(c, apiErr.New(http.StatusUnauthorized, "invalid token: missing Bearer"))
+					return
+				}
 				m.outputErr(c, apiErr.New(http.StatusBadRequest, "missing token"))
-				c.Abort()
 				return
 			}
-			reqUser := u.(auth.ReqUser)
 
 			host := util.GetHost(c.Request)
 			if m.isMatchHost && reqUser.Host() != host {
@@ -3,6 +3,7 @@ package mid
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	apiErr "github.com/94peter/sterna/api/err"
 	"github.com/94peter/sterna/auth"
@@ -48,8 +49,34 @@ func (m *reqUserTokenMiddle) Handler() gin.HandlerFunc {
 			return
 		}
 
-		c.Set(string(auth.CtxUserInfoKey), auth.NewReqUser(
-			result.Host(), result.Sub(), result.Account(),
-			result.Name(), result.Perms()))
+		c.Set(string(auth.CtxUserInfoKey), newReqUserByResult(result))
 	}
 }
+
+// MfaTokenResult is implemented by parser results carrying the mfa claim.
+type MfaTokenResult interface {
+	MfaAt() int64
+}
+
+// ActorTokenResult is implemented by parser results carrying the act claim
+// of an impersonation token.
+type ActorTokenResult interface {
+	Actor() interface{}
+}
+
+func newReqUserByResult(result TokenParserResult) auth.ReqUser {
+	u := auth.NewReqUser(
+		result.Host(),
+		result.Sub(),
+		result.Account(),
+		result.Name(),
+		result.Perms(),
+	)
+	if mr, ok := result.(MfaTokenResult); ok && mr.MfaAt() > 0 {
+		u = auth.WithMfa(u, time.Unix(mr.MfaAt(), 0))
+	}
+	if ar, ok := result.(ActorTokenResult); ok {
+		u = auth.Impersonate(u, auth.NewActorByClaim(result.Host(), ar.Actor()))
+	}
+	return u
+}
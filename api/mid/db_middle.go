@@ -2,12 +2,12 @@ package mid
 
 import (
 	"net/http"
-	"runtime"
 
 	"github.com/94peter/sterna"
 	apiErr "github.com/94peter/sterna/api/err"
 	"github.com/94peter/sterna/db"
 	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/tenant"
 	"github.com/94peter/sterna/util"
 	"github.com/gin-gonic/gin"
 
@@ -21,6 +21,16 @@ type DBMidDI interface {
 
 type DBMiddle string
 
+const RequestIDHeaderKey = "X-Request-Id"
+
+// getRequestID 沿用上游傳入的 request id，沒有時產生新的
+func getRequestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeaderKey); id != "" && len(id) <= 64 {
+		return id
+	}
+	return uuid.New().String()
+}
+
 func NewDBMid() Middle {
 	return &dbMiddle{}
 }
@@ -54,20 +64,21 @@ func (am *dbMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerFunc {
 			}
 
 			if dbdi, ok := servDi.(DBMidDI); ok {
-				uuid := uuid.New().String()
-				l := dbdi.NewLogger(uuid)
+				reqID := getRequestID(r)
+				l := dbdi.NewLogger(reqID)
 
-				dbclt, err := dbdi.NewMongoDBClient(r.Context(), "")
+				dbclt, err := dbdi.NewMongoDBClient(r.Context(), tenant.GetTenantByReq(r).GetMongoDB())
 				if err != nil {
 					w.WriteHeader(http.StatusInternalServerError)
 					w.Write([]byte(err.Error()))
 					return
 				}
 				defer dbclt.Close()
+				r = r.WithContext(db.WithTxHolder(r.Context()))
 				r = util.SetCtxKeyVal(r, db.CtxMongoKey, dbclt)
 				r = util.SetCtxKeyVal(r, log.CtxLogKey, l)
+				r = util.SetCtxKeyVal(r, db.CtxRequestIDKey, reqID)
 				f(w, r)
-				runtime.GC()
 			} else {
 				apiErr.OutputErr(w, apiErr.New(http.StatusInternalServerError, "invalid di"))
 				return
@@ -86,10 +97,10 @@ func (m *dbMiddle) Handler() gin.HandlerFunc {
 		}
 
 		if dbdi, ok := servDi.(DBMidDI); ok {
-			uuid := uuid.New().String()
-			l := dbdi.NewLogger(uuid)
+			reqID := getRequestID(c.Request)
+			l := dbdi.NewLogger(reqID)
 
-			dbclt, err := dbdi.NewMongoDBClient(c.Request.Context(), "")
+			dbclt, err := dbdi.NewMongoDBClient(c.Request.Context(), tenant.GetTenantByGin(c).GetMongoDB())
 			if err != nil {
 				m.outputErr(c, apiErr.New(http.StatusInternalServerError, err.Error()))
 				c.Abort()
@@ -98,10 +109,13 @@ func (m *dbMiddle) Handler() gin.HandlerFunc {
 			defer dbclt.Close()
 
 			c.Set(string(db.CtxMongoKey), dbclt)
+			// 以 request context 建立的 model 會自動加入 WithTransaction 進行中的交易
+			c.Request = c.Request.WithContext(db.WithTxHolder(c.Request.Context()))
+			c.Request = util.SetCtxKeyVal(c.Request, db.CtxMongoKey, dbclt)
+			c.Request = util.SetCtxKeyVal(c.Request, db.CtxRequestIDKey, reqID)
 			c.Set(string(log.CtxLogKey), l)
 
 			c.Next()
-			runtime.GC()
 		} else {
 			m.outputErr(c, apiErr.New(http.StatusInternalServerError, "invalid di"))
 			c.Abort()
@@ -0,0 +1,26 @@
+package mid
+
+import (
+	"net/http"
+
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ErrKeyImpersonateDenied = "impersonation_denied"
+)
+
+// DenyImpersonation rejects sensitive routes when the session is an
+// impersonation, the admin has to use their own session.
+func DenyImpersonation(service string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if auth.IsImpersonated(auth.GetUserByGin(c)) {
+			apiErr.GinOutputErr(c, service,
+				apiErr.NewWithKey(http.StatusForbidden, auth.ErrImpersonateDenied.Error(), ErrKeyImpersonateDenied))
+			return
+		}
+		c.Next()
+	}
+}
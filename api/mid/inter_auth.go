@@ -1,24 +1,73 @@
 package mid
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
+	apiErr "github.com/94peter/sterna/api/err"
 	"github.com/94peter/sterna/auth"
 	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/model/cache"
 	"github.com/94peter/sterna/util"
+	"github.com/gin-gonic/gin"
 	"github.com/gorilla/mux"
 )
 
+var ErrCircuitOpen = errors.New("token introspection unavailable")
+
+type InterAuthConf struct {
+	Url       string        `yaml:"url" validate:"url"`
+	Timeout   time.Duration `yaml:"timeout"`
+	CacheSize int           `yaml:"cacheSize"`
+	CacheTtl  time.Duration `yaml:"cacheTtl"`
+	// 連續失敗次數達 BreakerFailures 後暫停呼叫 BreakerCooldown
+	BreakerFailures int           `yaml:"breakerFailures"`
+	BreakerCooldown time.Duration `yaml:"breakerCooldown"`
+}
+
+func (conf *InterAuthConf) setDefault() {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 5 * time.Second
+	}
+	if conf.CacheSize <= 0 {
+		conf.CacheSize = 1000
+	}
+	if conf.CacheTtl <= 0 {
+		conf.CacheTtl = time.Minute
+	}
+	if conf.BreakerFailures <= 0 {
+		conf.BreakerFailures = 5
+	}
+	if conf.BreakerCooldown <= 0 {
+		conf.BreakerCooldown = 30 * time.Second
+	}
+}
+
 func NewInterAuthMid(url string) AuthMidInter {
+	return newInterAuthMiddle("", &InterAuthConf{Url: url})
+}
+
+func NewGinInterAuthMid(service string, conf *InterAuthConf) AuthGinMidInter {
+	return newInterAuthMiddle(service, conf)
+}
+
+func newInterAuthMiddle(service string, conf *InterAuthConf) *interAuthMiddle {
+	conf.setDefault()
 	return &interAuthMiddle{
-		url:      url,
-		authMap:  make(map[string]uint8),
-		groupMap: make(map[string][]auth.UserPerm),
+		service:   service,
+		url:       conf.Url,
+		client:    &http.Client{Timeout: conf.Timeout},
+		cache:     cache.NewLRU(conf.CacheSize, conf.CacheTtl),
+		breaker:   &circuitBreaker{threshold: conf.BreakerFailures, cooldown: conf.BreakerCooldown},
+		authPaths: newAuthPaths(),
 	}
 }
 
@@ -27,43 +76,43 @@ func (lm *interAuthMiddle) GetName() string {
 }
 
 type interAuthMiddle struct {
-	url      string
-	log      log.Logger
-	authMap  map[string]uint8
-	groupMap map[string][]auth.UserPerm
+	service string
+	url     string
+	client  *http.Client
+	cache   cache.LRU
+	breaker *circuitBreaker
+	log     log.Logger
+	authPaths
 }
 
-func (am *interAuthMiddle) AddAuthPath(path string, method string, isAuth bool, group []auth.UserPerm) {
-	value := uint8(0)
-	if isAuth {
-		value = value | authValue
-	}
-	key := getPathKey(path, method)
-	am.authMap[key] = uint8(value)
-	am.groupMap[key] = group
+func (lm *interAuthMiddle) outputErr(c *gin.Context, err error) {
+	apiErr.GinOutputErr(c, lm.service, err)
 }
 
-func (am *interAuthMiddle) IsAuth(path string, method string) bool {
-	key := getPathKey(path, method)
-	value, ok := am.authMap[key]
-	if ok {
-		return (value & authValue) > 0
+// introspect returns the cached result of the token, it calls the parser
+// url when missing.
+func (am *interAuthMiddle) introspect(host, token string) (TokenParserResult, error) {
+	h := sha256.Sum256([]byte(host + "\n" + token))
+	key := hex.EncodeToString(h[:])
+	if r, ok := am.cache.Get(key); ok {
+		return r.(TokenParserResult), nil
 	}
-	return false
-}
-
-func (am *interAuthMiddle) HasPerm(path, method string, perm []string) bool {
-	key := fmt.Sprintf("%s:%s", path, method)
-	groupAry, ok := am.groupMap[key]
-	if !ok || groupAry == nil || len(groupAry) == 0 {
-		return true
+	if !am.breaker.Allow() {
+		return nil, ErrCircuitOpen
 	}
-	for _, g := range groupAry {
-		if util.IsStrInList(string(g), perm...) {
-			return true
-		}
+	result, err := getParserToken(am.client, host, am.url, token)
+	// 只有連線失敗或服務錯誤才計入斷路器
+	var ie *introspectError
+	if err != nil && !(errors.As(err, &ie) && ie.status < http.StatusInternalServerError) {
+		am.breaker.Failure()
+		return nil, err
+	}
+	am.breaker.Success()
+	if err != nil {
+		return nil, err
 	}
-	return false
+	am.cache.Set(key, result)
+	return result, nil
 }
 
 func (am *interAuthMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerFunc {
@@ -89,7 +138,12 @@ func (am *interAuthMiddle) GetMiddleWare() func(f http.HandlerFunc) http.Handler
 					return
 				}
 				// 打api取得token內容
-				result, err := getParserToken(util.GetHost(r), am.url, authToken)
+				result, err := am.introspect(util.GetHost(r), authToken)
+				if err == ErrCircuitOpen {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					w.Write([]byte(err.Error()))
+					return
+				}
 				if err != nil {
 					w.WriteHeader(http.StatusUnauthorized)
 					w.Write([]byte(err.Error()))
@@ -107,21 +161,66 @@ func (am *interAuthMiddle) GetMiddleWare() func(f http.HandlerFunc) http.Handler
 					w.Write([]byte("permission error"))
 					return
 				}
-				r = util.SetCtxKeyVal(r, auth.CtxUserInfoKey, auth.NewReqUser(
-					result.Host(),
-					result.Sub(),
-					result.Account(),
-					result.Name(),
-					result.Perms(),
-				))
+				r = util.SetCtxKeyVal(r, auth.CtxUserInfoKey, newReqUserByResult(result))
 			}
 			f(w, r)
 		}
 	}
 }
 
-func getParserToken(host, url, token string) (TokenParserResult, error) {
-	client := http.Client{}
+func (m *interAuthMiddle) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.FullPath()
+		method := c.Request.Method
+		if path == "" {
+			m.outputErr(c, apiErr.New(http.StatusNotFound, "path not found"))
+			return
+		}
+		if m.IsAuth(path, method) {
+			authToken := c.GetHeader(BearerAuthTokenKey)
+			if authToken == "" {
+				m.outputErr(c, apiErr.New(http.StatusUnauthorized, "miss token"))
+				return
+			}
+			if !strings.HasPrefix(authToken, "Bearer ") {
+				m.outputErr(c, apiErr.New(http.StatusUnauthorized, "invalid token: missing Bearer"))
+				return
+			}
+			host := util.GetHost(c.Request)
+			result, err := m.introspect(host, authToken)
+			if err == ErrCircuitOpen {
+				m.outputErr(c, apiErr.New(http.StatusServiceUnavailable, err.Error()))
+				return
+			}
+			if err != nil {
+				m.outputErr(c, apiErr.New(http.StatusUnauthorized, "invalid token: "+err.Error()))
+				return
+			}
+			if result.Host() != host {
+				m.outputErr(c, apiErr.New(http.StatusUnauthorized,
+					fmt.Sprintf("host not match: [%s] is not [%s]", result.Host(), host)))
+				return
+			}
+			if hasPerm := m.HasPerm(path, method, result.Perms()); !hasPerm {
+				m.outputErr(c, apiErr.New(http.StatusUnauthorized, "permission error"))
+				return
+			}
+			c.Set(string(auth.CtxUserInfoKey), newReqUserByResult(result))
+		}
+		c.Next()
+	}
+}
+
+type introspectError struct {
+	status int
+	msg    string
+}
+
+func (e *introspectError) Error() string {
+	return e.msg
+}
+
+func getParserToken(client *http.Client, host, url, token string) (TokenParserResult, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -138,11 +237,11 @@ func getParserToken(host, url, token string) (TokenParserResult, error) {
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		data, err := ioutil.ReadAll(req.Body)
+		data, err := ioutil.ReadAll(res.Body)
 		if err != nil {
 			return nil, err
 		}
-		return nil, errors.New(string(data))
+		return nil, &introspectError{status: res.StatusCode, msg: string(data)}
 	}
 
 	pr := parseTokenResult{}
@@ -153,28 +252,102 @@ func getParserToken(host, url, token string) (TokenParserResult, error) {
 	return pr, nil
 }
 
+// parseTokenResult is the introspection json, missing or mistyped fields
+// decode as empty values instead of panic.
 type parseTokenResult map[string]interface{}
 
+func (pr parseTokenResult) getStr(key string) string {
+	s, _ := pr[key].(string)
+	return s
+}
+
 func (pr parseTokenResult) Account() string {
-	return pr["account"].(string)
+	return pr.getStr("account")
 }
 
 func (pr parseTokenResult) Host() string {
-	return pr["host"].(string)
+	return pr.getStr("host")
 }
 
 func (pr parseTokenResult) Name() string {
-	return pr["name"].(string)
+	return pr.getStr("name")
 }
 
 func (pr parseTokenResult) Perms() []string {
-	return pr["perms"].([]string)
+	switch perms := pr["perms"].(type) {
+	case []string:
+		return perms
+	case []interface{}:
+		result := make([]string, 0, len(perms))
+		for _, p := range perms {
+			if s, ok := p.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	case string:
+		if perms == "" {
+			return nil
+		}
+		return []string{perms}
+	}
+	return nil
 }
 
 func (pr parseTokenResult) Sub() string {
-	return pr["sub"].(string)
+	return pr.getStr("sub")
 }
 
 func (pr parseTokenResult) Target() string {
-	return pr["target"].(string)
+	return pr.getStr("target")
+}
+
+func (pr parseTokenResult) MfaAt() int64 {
+	at, _ := pr[auth.ClaimMfa].(float64)
+	return int64(at)
+}
+
+func (pr parseTokenResult) Actor() interface{} {
+	return pr[auth.ClaimActor]
+}
+
+type circuitBreaker struct {
+	lock      sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// Allow returns false while the breaker is open, after the cooldown only one
+// trial call is let through until it reports the result.
+func (b *circuitBreaker) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *circuitBreaker) Success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *circuitBreaker) Failure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+	b.trial = false
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
 }
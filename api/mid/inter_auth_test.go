@@ -0,0 +1,72 @@
+package mid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/94peter/sterna/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GinInterAuthCache(t *testing.T) {
+	calls := 0
+	parser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("bad token"))
+			return
+		}
+		w.Write([]byte(`{"host":"example.com","sub":"u1","account":"acc","name":"nam","perms":["editor"]}`))
+	}))
+	defer parser.Close()
+
+	gin.SetMode(gin.TestMode)
+	am := NewGinInterAuthMid("test", &InterAuthConf{Url: parser.URL, CacheTtl: time.Minute})
+	am.AddAuthPath("/doc", "GET", true, []auth.UserPerm{auth.PermViewer})
+	am.AddAuthPath("/admin", "GET", true, []auth.UserPerm{auth.PermAdmin})
+	r := gin.New()
+	r.Use(am.Handler())
+	r.GET("/doc", func(c *gin.Context) {
+		c.String(http.StatusOK, auth.GetUserByGin(c).GetAccount())
+	})
+	r.GET("/admin", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	do := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = "example.com"
+		req.Header.Set(BearerAuthTokenKey, "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("/doc", "good")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "acc", w.Body.String())
+	assert.Equal(t, http.StatusOK, do("/doc", "good").Code)
+	assert.Equal(t, 1, calls)
+
+	assert.Equal(t, http.StatusUnauthorized, do("/admin", "good").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("/doc", "bad").Code)
+	assert.Equal(t, 2, calls)
+}
+
+func Test_CircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{threshold: 2, cooldown: time.Hour}
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.False(t, b.Allow())
+	b.openUntil = time.Now()
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	b.Success()
+	assert.True(t, b.Allow())
+}
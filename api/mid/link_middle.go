@@ -0,0 +1,88 @@
+package mid
+
+import (
+	"errors"
+	"net/http"
+
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/model/mgom"
+	"github.com/94peter/sterna/util"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LinkTokenHeaderKey = "X-Link-Token"
+	LinkTokenQueryKey  = "link"
+)
+
+// NewGinLinkMid authenticates the scoped link token from the X-Link-Token
+// header or the link query and installs the auth.AccessGuest of the link,
+// requests without the token pass through. It must be used after the db
+// middleware, the audit is written to the core db.
+func NewGinLinkMid(service string, token auth.JwtToken, redis db.RedisClient) GinMiddle {
+	return &linkMiddle{
+		service: service,
+		token:   token,
+		redis:   redis,
+	}
+}
+
+type linkMiddle struct {
+	service string
+	token   auth.JwtToken
+	redis   db.RedisClient
+}
+
+func (lm *linkMiddle) GetName() string {
+	return "link"
+}
+
+func (lm *linkMiddle) outputErr(c *gin.Context, err error) {
+	apiErr.GinOutputErr(c, lm.service, err)
+}
+
+func (m *linkMiddle) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr := c.GetHeader(LinkTokenHeaderKey)
+		if tokenStr == "" {
+			tokenStr = c.Query(LinkTokenQueryKey)
+		}
+		if tokenStr == "" {
+			c.Next()
+			return
+		}
+		lc, err := auth.ParseLinkToken(m.token, tokenStr)
+		if err != nil {
+			m.outputErr(c, apiErr.New(http.StatusUnauthorized, err.Error()))
+			return
+		}
+		if lc.Host != util.GetHost(c.Request) {
+			m.outputErr(c, apiErr.New(http.StatusUnauthorized, "link host not match"))
+			return
+		}
+		dbclt := db.GetMgoDBClientByGin(c)
+		if dbclt == nil {
+			m.outputErr(c, apiErr.New(http.StatusInternalServerError, "db not set"))
+			return
+		}
+		model := mgom.NewMgoModel(c.Request.Context(), dbclt.GetCoreDB(), log.GetLogByGin(c))
+		u, err := auth.NewLinkService(model, m.redis).Use(lc, c.Request.Method, c.Request.URL.Path, c.ClientIP())
+		switch {
+		case err == nil:
+		case errors.Is(err, auth.ErrLinkScope):
+			m.outputErr(c, apiErr.New(http.StatusForbidden, err.Error()))
+			return
+		case errors.Is(err, auth.ErrLinkExhausted):
+			m.outputErr(c, apiErr.New(http.StatusGone, err.Error()))
+			return
+		default:
+			m.outputErr(c, apiErr.New(http.StatusInternalServerError, err.Error()))
+			return
+		}
+		c.Set(string(auth.CtxUserInfoKey), u)
+		c.Next()
+	}
+}
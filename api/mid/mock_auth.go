@@ -1,49 +1,124 @@
 package mid
 
 import (
+	"net/http"
 	"strings"
 
+	apiErr "github.com/94peter/sterna/api/err"
 	"github.com/94peter/sterna/auth"
 	"github.com/94peter/sterna/util"
 	"github.com/gin-gonic/gin"
 )
 
-func NewMockAuthMid() AuthGinMidInter {
-	return &mockAuthMiddle{}
+const (
+	// MockUserKey 指定要使用的 fixture user (以 Account 對應)
+	MockUserKey = "Mock_User"
+	// MockAnonymous 模擬沒有帶 token 的請求
+	MockAnonymous = "anonymous"
+
+	mockRole = "mock"
+)
+
+// MockUser is a fixture user of the mock auth middleware.
+type MockUser struct {
+	ID      string
+	Account string
+	Name    string
+	Perms   []string
+}
+
+// NewMockAuthMid is the auth middleware for unit tests, it records the auth
+// paths and enforces them like NewGinBearAuthMid. The user comes from the
+// fixture named by the Mock_User header or from the Mock_User_UID,
+// Mock_User_ACC, Mock_User_NAM and Mock_User_Roles headers, the default user
+// only has the "mock" role.
+func NewMockAuthMid(fixtures ...*MockUser) AuthGinMidInter {
+	m := &mockAuthMiddle{
+		fixtures:  make(map[string]*MockUser),
+		authPaths: newAuthPaths(),
+	}
+	for _, f := range fixtures {
+		m.fixtures[f.Account] = f
+	}
+	return m
 }
 
 type mockAuthMiddle struct {
+	fixtures map[string]*MockUser
+	authPaths
 }
 
 func (lm *mockAuthMiddle) GetName() string {
 	return "mockAuth"
 }
 
-func (am *mockAuthMiddle) AddAuthPath(path string, method string, isAuth bool, group []auth.UserPerm) {
+func (lm *mockAuthMiddle) outputErr(c *gin.Context, err error) {
+	apiErr.GinOutputErr(c, "mock", err)
+}
+
+func headerOrDefault(c *gin.Context, key, def string) string {
+	if v := c.GetHeader(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// getUser returns nil for anonymous requests.
+func (am *mockAuthMiddle) getUser(c *gin.Context) (auth.ReqUser, error) {
+	host := util.GetHost(c.Request)
+	if key := c.GetHeader(MockUserKey); key != "" {
+		if key == MockAnonymous {
+			return nil, nil
+		}
+		f, ok := am.fixtures[key]
+		if !ok {
+			return nil, apiErr.New(http.StatusUnauthorized, "unknown mock user: "+key)
+		}
+		return auth.NewReqUser(host, f.ID, f.Account, f.Name, f.Perms), nil
+	}
+	var roles []string
+	for _, r := range strings.Split(c.GetHeader("Mock_User_Roles"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roles = append(roles, r)
+		}
+	}
+	if len(roles) == 0 {
+		roles = []string{mockRole}
+	}
+	return auth.NewReqUser(host,
+		headerOrDefault(c, "Mock_User_UID", "mock-id"),
+		headerOrDefault(c, "Mock_User_ACC", "mock-account"),
+		headerOrDefault(c, "Mock_User_NAM", "mock-name"),
+		roles,
+	), nil
 }
 
 func (am *mockAuthMiddle) Handler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.GetHeader("Mock_User_UID")
-		if userID == "" {
-			userID = "mock-id"
+		path := c.FullPath()
+		method := c.Request.Method
+		if path == "" {
+			am.outputErr(c, apiErr.New(http.StatusNotFound, "path not found"))
+			return
 		}
-		userAcc := c.GetHeader("Mock_User_ACC")
-		if userAcc == "" {
-			userAcc = "mock-account"
+		reqUser, err := am.getUser(c)
+		if err != nil {
+			am.outputErr(c, err)
+			return
 		}
-		userName := c.GetHeader("Mock_User_NAM")
-		if userName == "" {
-			userName = "mock-name"
+		if am.IsAuth(path, method) {
+			if reqUser == nil {
+				am.outputErr(c, apiErr.New(http.StatusUnauthorized, "miss token"))
+				return
+			}
+			if hasPerm := am.HasPerm(path, method, reqUser.GetPerm()); !hasPerm {
+				am.outputErr(c, apiErr.New(http.StatusUnauthorized, "permission error"))
+				return
+			}
 		}
-		roles := strings.Split(c.GetHeader("Mock_User_Roles"), ",")
-		if len(roles) == 0 {
-			roles = []string{"mock"}
+		if reqUser != nil {
+			c.Set(string(auth.CtxUserInfoKey), reqUser)
 		}
-		c.Set(
-			string(auth.CtxUserInfoKey),
-			auth.NewReqUser(util.GetHost(c.Request), userID, userAcc, userName, roles),
-		)
 		c.Next()
 	}
 }
@@ -0,0 +1,53 @@
+package mid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/94peter/sterna/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MockAuthEnforce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	am := NewMockAuthMid(
+		&MockUser{ID: "1", Account: "editor", Name: "e", Perms: []string{"editor"}},
+		&MockUser{ID: "2", Account: "viewer", Name: "v", Perms: []string{"viewer"}},
+	)
+	am.AddAuthPath("/doc", "PUT", true, []auth.UserPerm{auth.PermEditor})
+	am.AddAuthPath("/doc", "GET", true, nil)
+	r := gin.New()
+	r.Use(am.Handler())
+	r.PUT("/doc", func(c *gin.Context) {
+		c.String(http.StatusOK, auth.GetUserByGin(c).GetAccount())
+	})
+	r.GET("/doc", func(c *gin.Context) {
+		c.String(http.StatusOK, auth.GetUserByGin(c).GetPerm()[0])
+	})
+
+	do := func(method string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/doc", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("PUT", map[string]string{MockUserKey: "editor"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "editor", w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, do("PUT", map[string]string{MockUserKey: "viewer"}).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("PUT", map[string]string{MockUserKey: "nobody"}).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", map[string]string{MockUserKey: MockAnonymous}).Code)
+	assert.Equal(t, http.StatusOK, do("PUT", map[string]string{"Mock_User_Roles": "admin"}).Code)
+
+	// 未指定角色時只有 mock 角色
+	assert.Equal(t, http.StatusUnauthorized, do("PUT", nil).Code)
+	w = do("GET", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mock", w.Body.String())
+}
@@ -4,33 +4,150 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/94peter/sterna"
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/event"
 	"github.com/94peter/sterna/util"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
 )
 
+const (
+	ServiceHeaderKey = "X-Service"
+	// DiInvalidateTopic 的訊息內容為 service 名稱，空白表示全部
+	DiInvalidateTopic = "sterna/di/invalidate"
+)
+
+// DiLoader loads the DI of service.
+type DiLoader func(service string) (interface{}, error)
+
+// NewUriDiLoader creates a new value of the type of di for every service and
+// fills it from servUri, which is formatted with the service and env.
+func NewUriDiLoader(di interface{}, servUri, env string) DiLoader {
+	val := reflect.ValueOf(di)
+	if val.Kind() == reflect.Ptr {
+		val = reflect.Indirect(val)
+	}
+	t := val.Type()
+	return func(service string) (interface{}, error) {
+		newValue := reflect.New(t).Interface()
+		err := sterna.InitConfByUri(fmt.Sprintf(servUri, service, env), newValue)
+		if err != nil {
+			return nil, err
+		}
+		return newValue, nil
+	}
+}
+
+// DiRegistry caches the DI of each service, it is safe for concurrent use.
+type DiRegistry interface {
+	Get(service string) (interface{}, error)
+	Invalidate(service string)
+	InvalidateAll()
+}
+
+// NewDiRegistry returns the registry loading by loader, the cached DI is
+// reloaded after ttl, ttl <= 0 keeps it until invalidated. Concurrent
+// requests of the same service share one load.
+func NewDiRegistry(loader DiLoader, ttl time.Duration) DiRegistry {
+	return &diRegistryImpl{
+		loader:  loader,
+		ttl:     ttl,
+		entries: make(map[string]*diEntry),
+	}
+}
+
+type diEntry struct {
+	di        interface{}
+	expiredAt time.Time
+}
+
+type diRegistryImpl struct {
+	loader  DiLoader
+	ttl     time.Duration
+	lock    sync.RWMutex
+	entries map[string]*diEntry
+	group   singleflight.Group
+}
+
+func (r *diRegistryImpl) Get(service string) (interface{}, error) {
+	r.lock.RLock()
+	e, ok := r.entries[service]
+	r.lock.RUnlock()
+	if ok && (r.ttl <= 0 || time.Now().Before(e.expiredAt)) {
+		return e.di, nil
+	}
+	di, err, _ := r.group.Do(service, func() (interface{}, error) {
+		di, err := r.loader(service)
+		if err != nil {
+			return nil, err
+		}
+		r.lock.Lock()
+		r.entries[service] = &diEntry{di: di, expiredAt: time.Now().Add(r.ttl)}
+		r.lock.Unlock()
+		return di, nil
+	})
+	return di, err
+}
+
+func (r *diRegistryImpl) Invalidate(service string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.entries, service)
+}
+
+func (r *diRegistryImpl) InvalidateAll() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries = make(map[string]*diEntry)
+}
+
+// NewDiInvalidateJob invalidates the registry by the event topic
+// DiInvalidateTopic, so all instances reload after the config changed.
+func NewDiInvalidateJob(registry DiRegistry) event.EventJob {
+	return &diInvalidateJob{registry: registry}
+}
+
+type diInvalidateJob struct {
+	registry DiRegistry
+}
+
+func (j *diInvalidateJob) GetTopic() string {
+	return DiInvalidateTopic
+}
+
+func (j *diInvalidateJob) GetHandler() event.EventHandler {
+	return func(data []byte) error {
+		if service := string(data); service != "" {
+			j.registry.Invalidate(service)
+		} else {
+			j.registry.InvalidateAll()
+		}
+		return nil
+	}
+}
+
 func NewServiceMid(di interface{}, servUri, env string) Middle {
+	return NewServiceMidByRegistry(NewDiRegistry(NewUriDiLoader(di, servUri, env), 0))
+}
+
+func NewServiceMidByRegistry(registry DiRegistry) Middle {
 	return &servMiddle{
-		servUri: servUri,
-		di:      di,
-		env:     env,
+		registry: registry,
 	}
 }
 
 type servMiddle struct {
-	servUri string
-	env     string
-	di      interface{}
+	registry DiRegistry
 }
 
 func (lm *servMiddle) GetName() string {
 	return "service"
 }
 
-var (
-	serviceDiMap = make(map[string]interface{})
-)
-
 const (
 	CtxServDiKey = util.CtxKey("ServiceDI")
 )
@@ -39,30 +156,59 @@ func (am *servMiddle) GetMiddleWare() func(f http.HandlerFunc) http.HandlerFunc
 	return func(f http.HandlerFunc) http.HandlerFunc {
 		// one time scope setup area for middleware
 		return func(w http.ResponseWriter, r *http.Request) {
-			service := r.Header.Get("X-Service")
+			service := r.Header.Get(ServiceHeaderKey)
 			if service == "" {
 				w.WriteHeader(http.StatusBadGateway)
 				return
 			}
-			var mydi interface{}
-			var ok bool
-			if mydi, ok = serviceDiMap[service]; !ok {
-				val := reflect.ValueOf(am.di)
-				if val.Kind() == reflect.Ptr {
-					val = reflect.Indirect(val)
-				}
-				newValue := reflect.New(val.Type()).Interface()
-				err := sterna.InitConfByUri(fmt.Sprintf(am.servUri, service, am.env), newValue)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					w.Write([]byte(err.Error()))
-					return
-				}
-				serviceDiMap[service] = newValue
-				mydi = newValue
+			mydi, err := am.registry.Get(service)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
 			}
 			r = util.SetCtxKeyVal(r, CtxServDiKey, mydi)
 			f(w, r)
 		}
 	}
 }
+
+// NewGinServiceMid sets the DI of the service in the X-Service header, the
+// db middleware reads it from sterna.CtxServDiKey.
+func NewGinServiceMid(service string, registry DiRegistry) GinMiddle {
+	return &ginServMiddle{
+		service:  service,
+		registry: registry,
+	}
+}
+
+type ginServMiddle struct {
+	service  string
+	registry DiRegistry
+}
+
+func (lm *ginServMiddle) GetName() string {
+	return "service"
+}
+
+func (lm *ginServMiddle) outputErr(c *gin.Context, err error) {
+	apiErr.GinOutputErr(c, lm.service, err)
+}
+
+func (m *ginServMiddle) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		service := c.GetHeader(ServiceHeaderKey)
+		if service == "" {
+			m.outputErr(c, apiErr.New(http.StatusBadGateway, "miss service"))
+			return
+		}
+		mydi, err := m.registry.Get(service)
+		if err != nil {
+			m.outputErr(c, apiErr.New(http.StatusInternalServerError, err.Error()))
+			return
+		}
+		c.Set(string(sterna.CtxServDiKey), mydi)
+		c.Request = util.SetCtxKeyVal(c.Request, sterna.CtxServDiKey, mydi)
+		c.Next()
+	}
+}
@@ -0,0 +1,43 @@
+package mid
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DiRegistry(t *testing.T) {
+	var loads int32
+	loader := func(service string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		return service, nil
+	}
+	r := NewDiRegistry(loader, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			di, err := r.Get("a")
+			assert.Nil(t, err)
+			assert.Equal(t, "a", di)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	NewDiInvalidateJob(r).GetHandler()([]byte("a"))
+	r.Get("a")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+
+	expiring := NewDiRegistry(loader, time.Millisecond)
+	expiring.Get("b")
+	time.Sleep(2 * time.Millisecond)
+	expiring.Get("b")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&loads))
+}
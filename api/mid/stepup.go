@@ -0,0 +1,34 @@
+package mid
+
+import (
+	"net/http"
+	"time"
+
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ErrKeyMfaRequired = "mfa_required"
+)
+
+// RequireStepUp rejects the request unless the token user passed MFA
+// within maxAge, the client should verify MFA again and retry with the
+// step-up token.
+func RequireStepUp(service string, maxAge time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u := auth.GetUserByGin(c)
+		if u == nil {
+			apiErr.GinOutputErr(c, service, apiErr.New(http.StatusUnauthorized, "miss token"))
+			return
+		}
+		at, ok := auth.GetMfaTime(u)
+		if !ok || time.Since(at) > maxAge {
+			apiErr.GinOutputErr(c, service,
+				apiErr.NewWithKey(http.StatusUnauthorized, "mfa step-up required", ErrKeyMfaRequired))
+			return
+		}
+		c.Next()
+	}
+}
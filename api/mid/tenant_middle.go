@@ -0,0 +1,94 @@
+package mid
+
+import (
+	"errors"
+	"net/http"
+
+	apiErr "github.com/94peter/sterna/api/err"
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/tenant"
+	"github.com/gin-gonic/gin"
+)
+
+type TenantGinMidInter interface {
+	GinMiddle
+	// AllowCrossTenant lets token users of the route resolve a tenant other
+	// than the one in their token.
+	AllowCrossTenant(path, method string) TenantGinMidInter
+}
+
+// NewGinTenantMid resolves the tenant with the first resolver returning a
+// non-empty id and puts it in the context, requests without tenant pass
+// through unless required is set. It must run after the auth middleware,
+// the tenant of a token user has to match the claim (tenant.FromClaim)
+// unless the route allows cross tenant.
+func NewGinTenantMid(service string, store tenant.Store, required bool, resolvers ...tenant.Resolver) TenantGinMidInter {
+	return &tenantMiddle{
+		service:     service,
+		store:       store,
+		required:    required,
+		resolvers:   resolvers,
+		crossTenant: make(map[string]bool),
+	}
+}
+
+type tenantMiddle struct {
+	service     string
+	store       tenant.Store
+	required    bool
+	resolvers   []tenant.Resolver
+	crossTenant map[string]bool
+}
+
+func (lm *tenantMiddle) GetName() string {
+	return "tenant"
+}
+
+func (lm *tenantMiddle) AllowCrossTenant(path, method string) TenantGinMidInter {
+	lm.crossTenant[getPathKey(path, method)] = true
+	return lm
+}
+
+func (lm *tenantMiddle) outputErr(c *gin.Context, err error) {
+	apiErr.GinOutputErr(c, lm.service, err)
+}
+
+func (m *tenantMiddle) Handler() gin.HandlerFunc {
+	claim := tenant.FromClaim()
+	return func(c *gin.Context) {
+		var id string
+		for _, r := range m.resolvers {
+			if id = r(c); id != "" {
+				break
+			}
+		}
+		// token user 只能使用自己的 tenant
+		if !auth.IsGuest(auth.GetUserByGin(c)) && !m.crossTenant[getPathKey(c.FullPath(), c.Request.Method)] {
+			if claimID := claim(c); id != claimID {
+				m.outputErr(c, apiErr.New(http.StatusForbidden, "tenant not allowed: "+id))
+				return
+			}
+		}
+		if id == "" {
+			if m.required {
+				m.outputErr(c, apiErr.New(http.StatusBadRequest, "missing tenant"))
+				return
+			}
+			c.Next()
+			return
+		}
+		t, err := m.store.Get(id)
+		if errors.Is(err, tenant.ErrTenantNotFound) {
+			m.outputErr(c, apiErr.New(http.StatusNotFound, "tenant not found: "+id))
+			return
+		}
+		if err != nil {
+			m.outputErr(c, apiErr.New(http.StatusInternalServerError, err.Error()))
+			return
+		}
+		// redis、storage 與 search 透過 request context 套用 tenant 的 prefix
+		c.Set(string(tenant.CtxTenantKey), t)
+		c.Request = c.Request.WithContext(t.NewContext(c.Request.Context()))
+		c.Next()
+	}
+}
@@ -0,0 +1,64 @@
+package mid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TenantMidClaim(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := tenant.NewStore(tenant.NewStaticLoader(map[string]*tenant.Tenant{
+		"a": {}, "b": {},
+	}), time.Minute)
+	tm := NewGinTenantMid("test", store, false, tenant.FromHeader(tenant.HeaderTenantKey), tenant.FromClaim())
+	tm.AllowCrossTenant("/cross", "GET")
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if c.GetHeader("Login") != "" {
+			c.Set(string(auth.CtxUserInfoKey), auth.NewCompUser("", "1", "u", "u", "a", "A", []string{"member"}))
+		}
+	})
+	r.Use(tm.Handler())
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, tenant.GetTenantByGin(c).ID)
+	}
+	r.GET("/doc", handler)
+	r.GET("/cross", handler)
+
+	do := func(path, login, tenantID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if login != "" {
+			req.Header.Set("Login", login)
+		}
+		if tenantID != "" {
+			req.Header.Set(tenant.HeaderTenantKey, tenantID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("/doc", "1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "a", w.Body.String())
+
+	w = do("/doc", "1", "b")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = do("/cross", "1", "b")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "b", w.Body.String())
+
+	// 未登入可由 header 指定
+	w = do("/doc", "", "b")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "b", w.Body.String())
+}
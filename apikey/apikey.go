@@ -0,0 +1,225 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/log"
+	"github.com/94peter/sterna/model/mgom"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	apiKeyC = "api_key"
+
+	keyPrefix = "sk_"
+	// 更新最後使用時間的最小間隔，避免每個請求都寫入
+	touchInterval = time.Minute
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid api key")
+	ErrKeyExpired = errors.New("api key expired")
+	ErrKeyRevoked = errors.New("api key revoked")
+	// ErrKeyDenied 以 api key 驗證的請求不可再建立 api key
+	ErrKeyDenied = errors.New("api key can not manage api keys")
+)
+
+type ApiKey struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	Hash       string             `bson:"hash" json:"-"`
+	Owner      string             `bson:"owner" json:"owner"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	ExpiredAt  *time.Time         `bson:"expiredAt,omitempty" json:"expiredAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	Revoked    bool               `bson:"revoked" json:"revoked"`
+
+	dao.CommonDoc `bson:",inline" json:"-"`
+}
+
+func (k *ApiKey) GetC() string {
+	return apiKeyC
+}
+
+func (k *ApiKey) GetDoc() interface{} {
+	return k
+}
+
+func (k *ApiKey) GetID() interface{} {
+	return k.ID
+}
+
+func (k *ApiKey) GetIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "owner", Value: 1}}},
+	}
+}
+
+func (k *ApiKey) IsExpired(now time.Time) bool {
+	return k.ExpiredAt != nil && now.After(*k.ExpiredAt)
+}
+
+// HashKey returns the stored form of a plain key.
+func HashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// newSecret returns a new plain key and its display prefix.
+func newSecret() (key string, prefix string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	p := make([]byte, 4)
+	if _, err = rand.Read(p); err != nil {
+		return "", "", err
+	}
+	prefix = keyPrefix + hex.EncodeToString(p)
+	return prefix + "." + base64.RawURLEncoding.EncodeToString(b), prefix, nil
+}
+
+type Service interface {
+	// Create returns the plain key, it is only shown once.
+	Create(owner, name string, scopes []string, exp time.Duration, u dao.LogUser) (string, *ApiKey, error)
+	Rotate(id string, u dao.LogUser) (string, *ApiKey, error)
+	Revoke(id string, u dao.LogUser) error
+	Get(id string) (*ApiKey, error)
+	List(owner string) ([]*ApiKey, error)
+	Authenticate(key string) (*ApiKey, error)
+}
+
+func NewService(model mgom.MgoDBModel, l log.Logger) Service {
+	return &serviceImpl{
+		model: model,
+		log:   l,
+	}
+}
+
+type serviceImpl struct {
+	model mgom.MgoDBModel
+	log   log.Logger
+}
+
+func (s *serviceImpl) Create(owner, name string, scopes []string, exp time.Duration, u dao.LogUser) (string, *ApiKey, error) {
+	key, prefix, err := newSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	doc := &ApiKey{
+		ID:     primitive.NewObjectID(),
+		Name:   name,
+		Prefix: prefix,
+		Hash:   HashKey(key),
+		Owner:  owner,
+		Scopes: scopes,
+	}
+	if exp > 0 {
+		t := time.Now().Add(exp)
+		doc.ExpiredAt = &t
+	}
+	if _, err = s.model.Save(doc, u); err != nil {
+		return "", nil, err
+	}
+	return key, doc, nil
+}
+
+func (s *serviceImpl) Get(id string) (*ApiKey, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	doc := &ApiKey{ID: oid}
+	if err = s.model.FindByID(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (s *serviceImpl) Rotate(id string, u dao.LogUser) (string, *ApiKey, error) {
+	doc, err := s.Get(id)
+	if err != nil {
+		return "", nil, err
+	}
+	if doc.Revoked {
+		return "", nil, ErrKeyRevoked
+	}
+	key, prefix, err := newSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	doc.Prefix = prefix
+	doc.Hash = HashKey(key)
+	_, err = s.model.UpdateOne(doc, bson.D{
+		{Key: "prefix", Value: doc.Prefix},
+		{Key: "hash", Value: doc.Hash},
+	}, u)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, doc, nil
+}
+
+func (s *serviceImpl) Revoke(id string, u dao.LogUser) error {
+	doc, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	doc.Revoked = true
+	_, err = s.model.UpdateOne(doc, bson.D{{Key: "revoked", Value: true}}, u)
+	return err
+}
+
+func (s *serviceImpl) List(owner string) ([]*ApiKey, error) {
+	q := bson.M{}
+	if owner != "" {
+		q["owner"] = owner
+	}
+	result, err := s.model.Find(&ApiKey{}, q)
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*ApiKey), nil
+}
+
+func (s *serviceImpl) Authenticate(key string) (*ApiKey, error) {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return nil, ErrInvalidKey
+	}
+	doc := &ApiKey{}
+	err := s.model.FindOne(doc, bson.M{"hash": HashKey(key)})
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doc.Revoked {
+		return nil, ErrKeyRevoked
+	}
+	now := time.Now()
+	if doc.IsExpired(now) {
+		return nil, ErrKeyExpired
+	}
+	if doc.LastUsedAt == nil || now.Sub(*doc.LastUsedAt) > touchInterval {
+		doc.LastUsedAt = &now
+		// 更新失敗不影響驗證結果
+		if _, err = s.model.UpdateOne(doc, bson.D{{Key: "lastUsedAt", Value: now}}, nil); err != nil && s.log != nil {
+			s.log.Warn(fmt.Sprintf("api key %s update lastUsedAt fail: %s", doc.Prefix, err.Error()))
+		}
+	}
+	return doc, nil
+}
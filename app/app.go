@@ -0,0 +1,247 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/94peter/sterna"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/event"
+	"github.com/94peter/sterna/kafka"
+	"github.com/94peter/sterna/log"
+	queue "github.com/94peter/sterna/que"
+)
+
+var ErrDiNotSupport = errors.New("di not support")
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// App wires the DI with its servers and workers. Run starts the hooks,
+// workers and servers in that order and stops them in reverse order on
+// SIGINT, SIGTERM or the first failure.
+type App struct {
+	di              sterna.CommonDI
+	log             log.Logger
+	servers         []Server
+	workers         []Worker
+	startHooks      []hook
+	stopHooks       []hook
+	eventJobs       []event.EventJob
+	evt             event.Event
+	ShutdownTimeout time.Duration
+}
+
+func New(di sterna.CommonDI) *App {
+	a := &App{
+		di:              di,
+		ShutdownTimeout: 30 * time.Second,
+	}
+	if ldi, ok := di.(log.LoggerDI); ok {
+		a.log = ldi.NewLogger(di.GetServiceName())
+	} else {
+		a.log = (&log.LoggerConf{}).NewLogger(di.GetServiceName())
+	}
+	return a
+}
+
+func (a *App) GetDI() sterna.CommonDI {
+	return a.di
+}
+
+func (a *App) Logger() log.Logger {
+	return a.log
+}
+
+// Mongo returns a client handle when the DI implements db.MongoDI, the
+// caller closes it. The pooled connections are closed on shutdown.
+func (a *App) Mongo(ctx context.Context, userDB string) (db.MongoDBClient, error) {
+	mdi, ok := a.di.(db.MongoDI)
+	if !ok {
+		return nil, fmt.Errorf("%w: db.MongoDI", ErrDiNotSupport)
+	}
+	return mdi.NewMongoDBClient(ctx, userDB)
+}
+
+// Redis returns the client of the db named name, it is closed on shutdown.
+func (a *App) Redis(ctx context.Context, name string) (db.RedisClient, error) {
+	rdi, ok := a.di.(db.RedisDI)
+	if !ok {
+		return nil, fmt.Errorf("%w: db.RedisDI", ErrDiNotSupport)
+	}
+	clt, err := rdi.NewRedisClientDB(ctx, rdi.GetDB(name))
+	if err != nil {
+		return nil, err
+	}
+	a.OnStop("redis:"+name, func(context.Context) error {
+		return clt.Close()
+	})
+	return clt, nil
+}
+
+// Queue returns the queue service when the DI implements queue.QueueDI.
+func (a *App) Queue() (queue.QueueServ, error) {
+	qdi, ok := a.di.(queue.QueueDI)
+	if !ok {
+		return nil, fmt.Errorf("%w: queue.QueueDI", ErrDiNotSupport)
+	}
+	return qdi.NewServ()
+}
+
+// AddKafkaReader reads topic in background when the DI implements
+// kafka.ConfigDI.
+func (a *App) AddKafkaReader(groupID, topic string, handler kafka.ReaderHandler) error {
+	kdi, ok := a.di.(kafka.ConfigDI)
+	if !ok {
+		return fmt.Errorf("%w: kafka.ConfigDI", ErrDiNotSupport)
+	}
+	a.AddWorker(NewKafkaWorker(kdi, groupID, topic, handler, a.log))
+	return nil
+}
+
+// AddEventJobs registers the jobs to the event of the DI on start.
+func (a *App) AddEventJobs(jobs ...event.EventJob) *App {
+	a.eventJobs = append(a.eventJobs, jobs...)
+	return a
+}
+
+// Event returns the event created on start, nil before Run.
+func (a *App) Event() event.Event {
+	return a.evt
+}
+
+func (a *App) AddServer(servers ...Server) *App {
+	a.servers = append(a.servers, servers...)
+	return a
+}
+
+func (a *App) AddWorker(workers ...Worker) *App {
+	a.workers = append(a.workers, workers...)
+	return a
+}
+
+// OnStart hooks run in the registered order before the workers.
+func (a *App) OnStart(name string, fn func(ctx context.Context) error) *App {
+	a.startHooks = append(a.startHooks, hook{name: name, fn: fn})
+	return a
+}
+
+// OnStop hooks run in reverse order after the servers and workers stopped.
+func (a *App) OnStop(name string, fn func(ctx context.Context) error) *App {
+	a.stopHooks = append(a.stopHooks, hook{name: name, fn: fn})
+	return a
+}
+
+func (a *App) start(ctx context.Context) error {
+	if err := a.di.IsConfEmpty(); err != nil {
+		return fmt.Errorf("conf error: %w", err)
+	}
+	if len(a.eventJobs) > 0 {
+		edi, ok := a.di.(event.EventDI)
+		if !ok {
+			return fmt.Errorf("%w: event.EventDI", ErrDiNotSupport)
+		}
+		a.evt = edi.NewEvent(a.di.GetServiceName(), a.log)
+		if a.evt == nil {
+			return errors.New("event not set")
+		}
+		a.evt.Register(a.eventJobs...)
+	}
+	for _, h := range a.startHooks {
+		a.log.Info("start: " + h.name)
+		if err := h.fn(ctx); err != nil {
+			return fmt.Errorf("start %s fail: %w", h.name, err)
+		}
+	}
+	return nil
+}
+
+// Run blocks until a signal is received or a server or worker fails.
+func (a *App) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return a.RunContext(ctx)
+}
+
+// RunContext is Run stopped by ctx instead of the signals.
+func (a *App) RunContext(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := a.start(ctx); err != nil {
+		a.stop()
+		return err
+	}
+
+	errCh := make(chan error, len(a.workers)+len(a.servers))
+	var wg sync.WaitGroup
+	for _, w := range a.workers {
+		wg.Add(1)
+		go func(w Worker) {
+			defer wg.Done()
+			a.log.Info("run worker: " + w.GetName())
+			if err := w.Run(ctx); err != nil && ctx.Err() == nil {
+				errCh <- fmt.Errorf("worker %s: %w", w.GetName(), err)
+			}
+		}(w)
+	}
+	for _, s := range a.servers {
+		go func(s Server) {
+			a.log.Info("start server: " + s.GetName())
+			if err := s.Start(); err != nil {
+				errCh <- fmt.Errorf("server %s: %w", s.GetName(), err)
+			}
+		}(s)
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		a.log.Info("shutting down")
+	case runErr = <-errCh:
+		a.log.Err(runErr.Error())
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), a.ShutdownTimeout)
+	defer shutdownCancel()
+	for i := len(a.servers) - 1; i >= 0; i-- {
+		s := a.servers[i]
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			a.log.Err(fmt.Sprintf("shutdown server %s: %v", s.GetName(), err))
+		}
+	}
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		a.log.Warn("workers not stopped before timeout")
+	}
+	a.stop()
+	return runErr
+}
+
+func (a *App) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.ShutdownTimeout)
+	defer cancel()
+	for i := len(a.stopHooks) - 1; i >= 0; i-- {
+		h := a.stopHooks[i]
+		if err := h.fn(ctx); err != nil {
+			a.log.Err(fmt.Sprintf("stop %s fail: %v", h.name, err))
+		}
+	}
+	if err := db.CloseMongoClients(ctx); err != nil {
+		a.log.Err("close mongo fail: " + err.Error())
+	}
+}
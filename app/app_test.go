@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testDI struct{}
+
+func (d *testDI) GetServiceName() string {
+	return "test"
+}
+
+func (d *testDI) IsConfEmpty() error {
+	return nil
+}
+
+func Test_AppOrder(t *testing.T) {
+	var steps []string
+	a := New(&testDI{})
+	a.OnStart("first", func(context.Context) error {
+		steps = append(steps, "start first")
+		return nil
+	}).OnStart("second", func(context.Context) error {
+		steps = append(steps, "start second")
+		return nil
+	}).OnStop("first", func(context.Context) error {
+		steps = append(steps, "stop first")
+		return nil
+	}).OnStop("second", func(context.Context) error {
+		steps = append(steps, "stop second")
+		return nil
+	})
+	a.AddWorker(NewWorker("w", func(ctx context.Context) error {
+		<-ctx.Done()
+		steps = append(steps, "worker done")
+		return nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, a.RunContext(ctx))
+	assert.Equal(t, []string{"start first", "start second", "worker done", "stop second", "stop first"}, steps)
+
+	failed := New(&testDI{}).AddWorker(NewWorker("bad", func(ctx context.Context) error {
+		return errors.New("boom")
+	}))
+	assert.Error(t, failed.RunContext(context.Background()))
+
+	_, err := failed.Mongo(context.Background(), "")
+	assert.ErrorIs(t, err, ErrDiNotSupport)
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/94peter/sterna/kafka"
+	"github.com/94peter/sterna/log"
+	queue "github.com/94peter/sterna/que"
+)
+
+// Server is started after the workers and shut down first.
+type Server interface {
+	GetName() string
+	// Start blocks until the server stops.
+	Start() error
+	Shutdown(ctx context.Context) error
+}
+
+// Worker runs in background until ctx is done.
+type Worker interface {
+	GetName() string
+	Run(ctx context.Context) error
+}
+
+// NewHttpServer serves h on addr, api.GinApiServer provides the handler
+// by Handler().
+func NewHttpServer(name, addr string, h http.Handler) Server {
+	return &httpServer{
+		name: name,
+		serv: &http.Server{Addr: addr, Handler: h},
+	}
+}
+
+type httpServer struct {
+	name string
+	serv *http.Server
+}
+
+func (s *httpServer) GetName() string {
+	return s.name
+}
+
+func (s *httpServer) Start() error {
+	err := s.serv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *httpServer) Shutdown(ctx context.Context) error {
+	return s.serv.Shutdown(ctx)
+}
+
+type workerFunc struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// NewWorker wraps run as a Worker.
+func NewWorker(name string, run func(ctx context.Context) error) Worker {
+	return &workerFunc{name: name, run: run}
+}
+
+func (w *workerFunc) GetName() string {
+	return w.name
+}
+
+func (w *workerFunc) Run(ctx context.Context) error {
+	return w.run(ctx)
+}
+
+// NewKafkaWorker reads topic with handler until ctx is done, handler errors
+// are logged and the reading goes on.
+func NewKafkaWorker(di kafka.ConfigDI, groupID, topic string, handler kafka.ReaderHandler, l log.Logger) Worker {
+	return NewWorker("kafka:"+topic, func(ctx context.Context) error {
+		reader := di.NewKafkaReader(ctx, groupID, topic, l)
+		defer reader.Close()
+		for {
+			err := reader.ReadHandler(handler)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				l.Err("kafka " + topic + ": " + err.Error())
+			}
+		}
+	})
+}
+
+// NewQueueWorker registers the tasks and launches the queue worker, the
+// machinery worker quits with the process.
+func NewQueueWorker(serv queue.QueueServ, workers ...queue.Worker) Worker {
+	return NewWorker("queue", func(ctx context.Context) error {
+		if err := serv.RegisterTasks(workers...); err != nil {
+			return err
+		}
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- serv.StartWorker()
+		}()
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errCh:
+			return err
+		}
+	})
+}
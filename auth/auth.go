@@ -1,12 +1,8 @@
 package auth
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
 	"net/http"
-	"strings"
 
 	"github.com/94peter/sterna/dao"
 	"github.com/94peter/sterna/util"
@@ -16,12 +12,7 @@ import (
 type UserPerm string
 
 func (up UserPerm) Validate() bool {
-	switch up {
-	case PermAdmin, PermOwner, PermEditor, PermViewer, PermGuest:
-		return true
-	default:
-		return false
-	}
+	return up.IsRole() || up.IsPermission()
 }
 
 const (
@@ -49,6 +40,9 @@ type ReqUser interface {
 	GetId() string
 	GetPerm() []string
 	GetDB() string
+	// GetActor returns the user really operating when the session is an
+	// impersonation, nil otherwise.
+	GetActor() ReqUser
 	Encode() string
 	Decode(data string) error
 }
@@ -63,13 +57,10 @@ type reqUserImpl struct {
 
 type Perms []string
 
+// HasPerm checks pp against DefaultPolicy, pp may be a role or a
+// resource:action permission.
 func (p Perms) HasPerm(pp string) bool {
-	for _, s := range p {
-		if s == pp {
-			return true
-		}
-	}
-	return false
+	return DefaultPolicy.Allow(p, []UserPerm{UserPerm(pp)})
 }
 
 func (ru *reqUserImpl) Host() string {
@@ -97,37 +88,20 @@ func (ru *reqUserImpl) GetPerm() []string {
 	return ru.perm
 }
 
-func (ru *reqUserImpl) Encode() string {
-	var network bytes.Buffer
-	enc := json.NewEncoder(&network)
-	enc.Encode(serializeObj{
-		1: ru.host,
-		2: ru.id,
-		3: ru.acc,
-		4: ru.name,
-		5: ru.perm,
-	})
-	return strings.Trim(network.String(), "\n")
+func (ru *reqUserImpl) GetActor() ReqUser {
+	return nil
 }
 
-type serializeObj map[int]interface{}
+func (ru *reqUserImpl) Encode() string {
+	return encodeString(ru)
+}
 
 func (ru *reqUserImpl) Decode(data string) error {
-	b := bytes.NewBufferString(data)
-	dec := json.NewDecoder(b)
-	result := serializeObj{}
-	err := dec.Decode(&result)
+	u, err := decodeInto(data, ru)
 	if err != nil {
 		return err
 	}
-	permInters := result[5].([]interface{})
-	for _, i := range permInters {
-		ru.perm = append(ru.perm, i.(string))
-	}
-	ru.host = result[1].(string)
-	ru.id = result[2].(string)
-	ru.acc = result[3].(string)
-	ru.name = result[4].(string)
+	*ru = *u.(*reqUserImpl)
 	return nil
 }
 
@@ -193,36 +167,20 @@ func (ru *accessGuestImpl) GetPerm() []string {
 	return ru.perm
 }
 
+func (ru *accessGuestImpl) GetActor() ReqUser {
+	return nil
+}
+
 func (ru *accessGuestImpl) Encode() string {
-	var network bytes.Buffer
-	enc := json.NewEncoder(&network)
-	enc.Encode(serializeObj{
-		1: ru.host,
-		2: ru.source,
-		3: ru.sourceID,
-		4: ru.dB,
-		5: ru.account,
-		6: ru.name,
-		7: ru.perm,
-	})
-	return strings.Trim(network.String(), "\n")
+	return encodeString(ru)
 }
 
 func (ru *accessGuestImpl) Decode(data string) error {
-	b := bytes.NewBufferString(data)
-	dec := json.NewDecoder(b)
-	result := serializeObj{}
-	err := dec.Decode(&result)
+	u, err := decodeInto(data, ru)
 	if err != nil {
 		return err
 	}
-	ru.host = result[1].(string)
-	ru.source = result[2].(string)
-	ru.sourceID = result[3].(string)
-	ru.dB = result[4].(string)
-	ru.account = result[5].(string)
-	ru.name = result[6].(string)
-	ru.perm = result[7].([]string)
+	*ru = *u.(*accessGuestImpl)
 	return nil
 }
 
@@ -262,8 +220,21 @@ func (c compUserImpl) GetComp() string {
 	return c.Comp
 }
 
+func (c *compUserImpl) Encode() string {
+	return encodeString(c)
+}
+
+func (c *compUserImpl) Decode(data string) error {
+	u, err := decodeInto(data, c)
+	if err != nil {
+		return err
+	}
+	*c = *u.(*compUserImpl)
+	return nil
+}
+
 func NewCompUser(host, uid, acc, name, compID, comp string, perm []string) CompanyUser {
-	return compUserImpl{
+	return &compUserImpl{
 		reqUserImpl: &reqUserImpl{
 			host: host,
 			acc:  acc,
@@ -313,29 +284,32 @@ func (ru *guestUser) GetPerm() []string {
 	return []string{string(PermGuest)}
 }
 
+func (ru *guestUser) GetActor() ReqUser {
+	return nil
+}
+
 func (ru *guestUser) Encode() string {
-	var network bytes.Buffer
-	enc := gob.NewEncoder(&network)
-	enc.Encode(serializeObj{
-		1: ru.host,
-		2: ru.ip,
-	})
-	return strings.Trim(network.String(), "\n")
+	return encodeString(ru)
 }
 
 func (ru *guestUser) Decode(data string) error {
-	b := bytes.NewBufferString(data)
-	dec := gob.NewDecoder(b)
-	result := serializeObj{}
-	err := dec.Decode(&result)
+	u, err := decodeInto(data, ru)
 	if err != nil {
 		return err
 	}
-	ru.host = result[1].(string)
-	ru.ip = result[2].(string)
+	*ru = *u.(*guestUser)
 	return nil
 }
 
+// IsGuest reports whether u is not a token user.
+func IsGuest(u ReqUser) bool {
+	if u == nil {
+		return true
+	}
+	_, ok := u.(*guestUser)
+	return ok
+}
+
 func GetUserInfo(req *http.Request) ReqUser {
 	return GetUserInfoByCtx(req.Context())
 }
@@ -399,3 +373,16 @@ type targetReqUserImpl struct {
 func (r *targetReqUserImpl) Target() string {
 	return r.target
 }
+
+func (r *targetReqUserImpl) Encode() string {
+	return encodeString(r)
+}
+
+func (r *targetReqUserImpl) Decode(data string) error {
+	u, err := decodeInto(data, r)
+	if err != nil {
+		return err
+	}
+	*r = *u.(*targetReqUserImpl)
+	return nil
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// 序列化格式版本，格式變更時遞增並保留舊版的解碼
+const codecVersion = 1
+
+const (
+	UserTypeUser   = "user"
+	UserTypeAccess = "access"
+	UserTypeComp   = "comp"
+	UserTypeGuest  = "guest"
+	UserTypeTarget = "target"
+	UserTypeMfa    = "mfa"
+	UserTypeImp    = "imp"
+)
+
+// UserHeaderKey is the kafka header / pubsub attribute carrying the encoded user.
+const UserHeaderKey = "X-Req-User"
+
+var (
+	ErrUnknownUserType  = errors.New("unknown req user type")
+	ErrUserTypeMismatch = errors.New("req user type mismatch")
+)
+
+// encodedUser is the wire format of every ReqUser, wrappers such as
+// TargetReqUser keep the wrapped user in Inner.
+type encodedUser struct {
+	Version  int          `json:"v"`
+	Type     string       `json:"t"`
+	Host     string       `json:"h,omitempty"`
+	ID       string       `json:"i,omitempty"`
+	Account  string       `json:"a,omitempty"`
+	Name     string       `json:"n,omitempty"`
+	Perm     []string     `json:"p,omitempty"`
+	DB       string       `json:"d,omitempty"`
+	Source   string       `json:"s,omitempty"`
+	SourceID string       `json:"si,omitempty"`
+	CompID   string       `json:"ci,omitempty"`
+	Comp     string       `json:"c,omitempty"`
+	Target   string       `json:"tg,omitempty"`
+	MfaAt    int64        `json:"m,omitempty"`
+	Inner    *encodedUser `json:"u,omitempty"`
+	Actor    *encodedUser `json:"act,omitempty"`
+}
+
+// EncodeReqUser serializes u with its type tag, DecodeReqUser restores the
+// same implementation.
+func EncodeReqUser(u ReqUser) (string, error) {
+	e, err := encodeUser(u)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func DecodeReqUser(data string) (ReqUser, error) {
+	e, err := parseEncodedUser(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeUser(e)
+}
+
+func encodeUser(u ReqUser) (*encodedUser, error) {
+	e := &encodedUser{Version: codecVersion}
+	switch ru := u.(type) {
+	case *reqUserImpl:
+		e.Type = UserTypeUser
+		e.Host, e.ID, e.Account, e.Name, e.Perm = ru.host, ru.id, ru.acc, ru.name, ru.perm
+	case *accessGuestImpl:
+		e.Type = UserTypeAccess
+		e.Host, e.Account, e.Name, e.Perm, e.DB = ru.host, ru.account, ru.name, ru.perm, ru.dB
+		e.Source, e.SourceID = ru.source, ru.sourceID
+	case *compUserImpl:
+		e.Type = UserTypeComp
+		e.Host, e.ID, e.Account, e.Name, e.Perm = ru.host, ru.id, ru.acc, ru.name, ru.perm
+		e.CompID, e.Comp = ru.CompID, ru.Comp
+	case *guestUser:
+		e.Type = UserTypeGuest
+		e.Host, e.ID = ru.host, ru.ip
+	case *targetReqUserImpl:
+		inner, err := encodeUser(ru.ReqUser)
+		if err != nil {
+			return nil, err
+		}
+		e.Type, e.Target, e.Inner = UserTypeTarget, ru.target, inner
+	case *mfaReqUserImpl:
+		inner, err := encodeUser(ru.ReqUser)
+		if err != nil {
+			return nil, err
+		}
+		e.Type, e.MfaAt, e.Inner = UserTypeMfa, ru.mfaAt.Unix(), inner
+	case *impersonatedUserImpl:
+		inner, err := encodeUser(ru.ReqUser)
+		if err != nil {
+			return nil, err
+		}
+		actor, err := encodeUser(ru.actor)
+		if err != nil {
+			return nil, err
+		}
+		e.Type, e.Inner, e.Actor = UserTypeImp, inner, actor
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnknownUserType, u)
+	}
+	return e, nil
+}
+
+func parseEncodedUser(data string) (*encodedUser, error) {
+	e := &encodedUser{}
+	if err := json.Unmarshal([]byte(data), e); err != nil {
+		return nil, err
+	}
+	if e.Version == 0 && e.Type == "" {
+		return parseLegacyUser(data)
+	}
+	if e.Version > codecVersion {
+		return nil, fmt.Errorf("not support req user version: %d", e.Version)
+	}
+	return e, nil
+}
+
+// parseLegacyUser reads the integer keyed json written by the old
+// reqUserImpl and accessGuestImpl Encode.
+func parseLegacyUser(data string) (*encodedUser, error) {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, err
+	}
+	str := func(k string) string {
+		s, _ := m[k].(string)
+		return s
+	}
+	strs := func(k string) (result []string) {
+		l, _ := m[k].([]interface{})
+		for _, i := range l {
+			if s, ok := i.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return
+	}
+	if _, ok := m["7"]; ok {
+		return &encodedUser{
+			Type: UserTypeAccess, Host: str("1"), Source: str("2"), SourceID: str("3"),
+			DB: str("4"), Account: str("5"), Name: str("6"), Perm: strs("7"),
+		}, nil
+	}
+	if _, ok := m["5"]; ok {
+		return &encodedUser{
+			Type: UserTypeUser, Host: str("1"), ID: str("2"), Account: str("3"),
+			Name: str("4"), Perm: strs("5"),
+		}, nil
+	}
+	return nil, ErrUnknownUserType
+}
+
+func decodeUser(e *encodedUser) (ReqUser, error) {
+	switch e.Type {
+	case UserTypeUser:
+		return &reqUserImpl{host: e.Host, id: e.ID, acc: e.Account, name: e.Name, perm: e.Perm}, nil
+	case UserTypeAccess:
+		return &accessGuestImpl{host: e.Host, source: e.Source, sourceID: e.SourceID,
+			dB: e.DB, account: e.Account, name: e.Name, perm: e.Perm}, nil
+	case UserTypeComp:
+		return &compUserImpl{
+			reqUserImpl: &reqUserImpl{host: e.Host, id: e.ID, acc: e.Account, name: e.Name, perm: e.Perm},
+			CompID:      e.CompID,
+			Comp:        e.Comp,
+		}, nil
+	case UserTypeGuest:
+		return &guestUser{host: e.Host, ip: e.ID}, nil
+	case UserTypeTarget, UserTypeMfa:
+		if e.Inner == nil {
+			return nil, fmt.Errorf("%s user missing inner user", e.Type)
+		}
+		inner, err := decodeUser(e.Inner)
+		if err != nil {
+			return nil, err
+		}
+		if e.Type == UserTypeTarget {
+			return &targetReqUserImpl{ReqUser: inner, target: e.Target}, nil
+		}
+		return &mfaReqUserImpl{ReqUser: inner, mfaAt: time.Unix(e.MfaAt, 0)}, nil
+	case UserTypeImp:
+		if e.Inner == nil || e.Actor == nil {
+			return nil, fmt.Errorf("%s user missing inner user or actor", e.Type)
+		}
+		inner, err := decodeUser(e.Inner)
+		if err != nil {
+			return nil, err
+		}
+		actor, err := decodeUser(e.Actor)
+		if err != nil {
+			return nil, err
+		}
+		return &impersonatedUserImpl{ReqUser: inner, actor: actor}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownUserType, e.Type)
+}
+
+// encodeString is used by the Encode method of every implementation.
+func encodeString(u ReqUser) string {
+	s, _ := EncodeReqUser(u)
+	return s
+}
+
+// decodeInto decodes data and checks it has the same type as dst.
+func decodeInto(data string, dst ReqUser) (ReqUser, error) {
+	u, err := DecodeReqUser(data)
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprintf("%T", u) != fmt.Sprintf("%T", dst) {
+		return nil, fmt.Errorf("%w: %T is not %T", ErrUserTypeMismatch, u, dst)
+	}
+	return u, nil
+}
+
+// SetUserHeader sets the encoded user in kafka message headers, an existing
+// user header is replaced.
+func SetUserHeader(headers []kafka.Header, u ReqUser) ([]kafka.Header, error) {
+	s, err := EncodeReqUser(u)
+	if err != nil {
+		return headers, err
+	}
+	for i, h := range headers {
+		if h.Key == UserHeaderKey {
+			headers[i].Value = []byte(s)
+			return headers, nil
+		}
+	}
+	return append(headers, kafka.Header{Key: UserHeaderKey, Value: []byte(s)}), nil
+}
+
+// GetUserFromHeader reads the user from kafka message headers,
+// it returns nil when the message carries no user.
+func GetUserFromHeader(headers []kafka.Header) (ReqUser, error) {
+	for _, h := range headers {
+		if h.Key != UserHeaderKey {
+			continue
+		}
+		if strings.TrimSpace(string(h.Value)) == "" {
+			return nil, nil
+		}
+		return DecodeReqUser(string(h.Value))
+	}
+	return nil, nil
+}
+
+// SetUserAttr adds the encoded user to pubsub message attributes.
+func SetUserAttr(attrs map[string]string, u ReqUser) error {
+	s, err := EncodeReqUser(u)
+	if err != nil {
+		return err
+	}
+	attrs[UserHeaderKey] = s
+	return nil
+}
+
+// GetUserByHeader reads the user from pubsub attributes or headers already
+// converted to a map,
+// it returns nil when the message carries no user.
+func GetUserByHeader(headers map[string]string) (ReqUser, error) {
+	s, ok := headers[UserHeaderKey]
+	if !ok || strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	return DecodeReqUser(s)
+}
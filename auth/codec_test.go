@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ReqUserCodec(t *testing.T) {
+	users := []ReqUser{
+		NewReqUser("h", "uid", "acc", "name", []string{"editor"}),
+		NewAccessGuest("h", "doc", "sid", "acc", "guest", "db", []string{"viewer"}),
+		NewCompUser("h", "uid", "acc", "name", "cid", "comp", []string{"owner"}),
+		NewGuestUser("h", "127.0.0.1"),
+		NewTargetReqUser("target", NewCompUser("h", "uid", "acc", "name", "cid", "comp", nil)),
+		WithMfa(NewReqUser("h", "uid", "acc", "name", nil), time.Unix(1700000000, 0)),
+		Impersonate(NewReqUser("h", "uid", "acc", "name", nil), NewReqUser("h", "aid", "admin", "staff", []string{"admin"})),
+	}
+	for _, u := range users {
+		s, err := EncodeReqUser(u)
+		assert.Nil(t, err)
+		d, err := DecodeReqUser(s)
+		assert.Nil(t, err)
+		assert.Equal(t, u, d)
+		assert.Equal(t, s, u.Encode())
+	}
+
+	c := &compUserImpl{}
+	assert.Nil(t, c.Decode(users[2].Encode()))
+	assert.Equal(t, "cid", c.GetCompID())
+	assert.ErrorIs(t, c.Decode(users[0].Encode()), ErrUserTypeMismatch)
+
+	// 舊版格式
+	ag := &accessGuestImpl{}
+	assert.Nil(t, ag.Decode(`{"1":"h","2":"doc","3":"sid","4":"db","5":"acc","6":"guest","7":["viewer"]}`))
+	assert.Equal(t, []string{"viewer"}, ag.GetPerm())
+
+	headers := map[string]string{}
+	assert.Nil(t, SetUserAttr(headers, users[1]))
+	u, err := GetUserByHeader(headers)
+	assert.Nil(t, err)
+	assert.Equal(t, users[1], u)
+
+	kh := []kafka.Header{{Key: "trace", Value: []byte("t1")}}
+	kh, err = SetUserHeader(kh, users[0])
+	assert.Nil(t, err)
+	kh, err = SetUserHeader(kh, users[1])
+	assert.Nil(t, err)
+	assert.Len(t, kh, 2)
+	u, err = GetUserFromHeader(kh)
+	assert.Nil(t, err)
+	assert.Equal(t, users[1], u)
+
+	u, err = GetUserFromHeader(kh[:1])
+	assert.Nil(t, err)
+	assert.Nil(t, u)
+}
@@ -0,0 +1,96 @@
+package auth
+
+import "errors"
+
+// ClaimActor 為代理登入時實際操作的管理者
+const ClaimActor = "act"
+
+var ErrImpersonateDenied = errors.New("impersonation not allowed")
+
+// Impersonate returns u operated by actor, GetActor of the result returns
+// actor and the doc records keep both accounts.
+func Impersonate(u, actor ReqUser) ReqUser {
+	if u == nil || actor == nil {
+		return u
+	}
+	return &impersonatedUserImpl{
+		ReqUser: u,
+		actor:   actor,
+	}
+}
+
+func IsImpersonated(u ReqUser) bool {
+	return u != nil && u.GetActor() != nil
+}
+
+// SetActorClaim adds the act claim of actor to the token data of the
+// impersonated user.
+func SetActorClaim(data map[string]interface{}, actor ReqUser) map[string]interface{} {
+	data[ClaimActor] = map[string]interface{}{
+		"sub": actor.GetId(),
+		"acc": actor.GetAccount(),
+		"nam": actor.GetName(),
+		"per": actor.GetPerm(),
+	}
+	return data
+}
+
+// NewActorByClaim restores the actor from the act claim, it returns nil
+// when the claim is missing or has no account.
+func NewActorByClaim(host string, claim interface{}) ReqUser {
+	act, ok := claim.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	str := func(k string) string {
+		s, _ := act[k].(string)
+		return s
+	}
+	if str("acc") == "" {
+		return nil
+	}
+	var perm []string
+	switch p := act["per"].(type) {
+	case []interface{}:
+		for _, i := range p {
+			if s, ok := i.(string); ok {
+				perm = append(perm, s)
+			}
+		}
+	case []string:
+		perm = p
+	case string:
+		perm = []string{p}
+	}
+	return NewReqUser(host, str("sub"), str("acc"), str("nam"), perm)
+}
+
+type impersonatedUserImpl struct {
+	ReqUser
+	actor ReqUser
+}
+
+func (u *impersonatedUserImpl) GetActor() ReqUser {
+	return u.actor
+}
+
+func (u *impersonatedUserImpl) GetActorAccount() string {
+	return u.actor.GetAccount()
+}
+
+func (u *impersonatedUserImpl) GetActorName() string {
+	return u.actor.GetName()
+}
+
+func (u *impersonatedUserImpl) Encode() string {
+	return encodeString(u)
+}
+
+func (u *impersonatedUserImpl) Decode(data string) error {
+	d, err := decodeInto(data, u)
+	if err != nil {
+		return err
+	}
+	*u = *d.(*impersonatedUserImpl)
+	return nil
+}
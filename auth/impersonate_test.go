@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Impersonate(t *testing.T) {
+	admin := NewReqUser("h", "aid", "admin@x", "staff", []string{"admin"})
+	claims := SetActorClaim(map[string]interface{}{"acc": "user@x"}, admin)
+	actor := NewActorByClaim("h", claims[ClaimActor])
+	assert.Equal(t, admin, actor)
+
+	u := Impersonate(NewReqUser("h", "uid", "user@x", "user", []string{"viewer"}), actor)
+	assert.True(t, IsImpersonated(u))
+	assert.False(t, IsImpersonated(admin))
+	assert.Equal(t, "admin@x", u.GetActor().GetAccount())
+
+	r := dao.NewUserRecord(time.Now(), u, "updated")
+	assert.Equal(t, "user@x", r.Account)
+	assert.Equal(t, "admin@x", r.ActorAccount)
+	assert.Equal(t, "staff", r.ActorName)
+
+	assert.Nil(t, NewActorByClaim("h", nil))
+}
@@ -15,7 +15,11 @@ type JwtToken interface {
 	ParseToken(tokenStr string) (*jwt.Token, error)
 	ParseTokenUnValidate(tokenStr string) (*jwt.Token, error)
 	// 對特定資源存取金鑰
+	//
+	// Deprecated: the token never expires, use GetLinkToken.
 	GetJwtAccessToken(host string, source string, id interface{}, db string, perm UserPerm) (*string, error)
+	// GetLinkToken 產生有期限、限定路徑與方法的分享連結 token
+	GetLinkToken(host string, scope *LinkScope) (*string, error)
 	GetCompanyToken(host, compID, compName, userID, acc, userName string, perm UserPerm) (*string, error)
 }
 
@@ -201,6 +205,7 @@ func (j *JwtConf) GetTokenWithoutExpired(host string, data map[string]interface{
 	return &ss, nil
 }
 
+// Deprecated: use GetLinkToken.
 func (j *JwtConf) GetJwtAccessToken(host string, source string, id interface{}, db string, perm UserPerm) (*string, error) {
 	if j == nil {
 		return nil, errors.New("jwtConf not set")
@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/model/mgom"
+	jwt "github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	UsageLink = "link"
+
+	linkAuditC     = "link_audit"
+	linkUseKeyTpl  = "link:use:%s"
+	maxLinkExpired = 30 * 24 * time.Hour
+)
+
+var (
+	ErrLinkInvalid   = errors.New("invalid link token")
+	ErrLinkScope     = errors.New("link not allowed for this request")
+	ErrLinkExhausted = errors.New("link usage exhausted")
+)
+
+// LinkScope describes what a scoped link may access.
+type LinkScope struct {
+	// 路徑樣式，以 / 分段比對，:name 或 * 符合任一段，例如 /file/:id/download
+	Path string
+	// 未設定時只允許 GET 與 HEAD
+	Methods []string
+	Source  string
+	// SourceID 為被分享資源的 id
+	SourceID string
+	DB       string
+	Perm     UserPerm
+	// 0 為不限次數，1 為單次使用
+	MaxUses int
+	Exp     time.Duration
+}
+
+// LinkClaims is the parsed content of a link token.
+type LinkClaims struct {
+	Host      string
+	Jti       string
+	Path      string
+	Methods   []string
+	Source    string
+	SourceID  string
+	DB        string
+	Perm      UserPerm
+	MaxUses   int
+	ExpiredAt time.Time
+}
+
+func newJti() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (j *JwtConf) GetLinkToken(host string, scope *LinkScope) (*string, error) {
+	if j == nil {
+		return nil, errors.New("jwtConf not set")
+	}
+	if scope == nil || scope.Path == "" {
+		return nil, errors.New("link path not set")
+	}
+	if scope.Exp <= 0 || scope.Exp > maxLinkExpired {
+		return nil, fmt.Errorf("link exp must be in (0, %s]", maxLinkExpired)
+	}
+	jti, err := newJti()
+	if err != nil {
+		return nil, err
+	}
+	methods := make([]string, len(scope.Methods))
+	for i, m := range scope.Methods {
+		methods[i] = strings.ToUpper(m)
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":      host,
+		"iat":      now.Unix(),
+		"exp":      now.Add(scope.Exp).Unix(),
+		"jti":      jti,
+		"path":     scope.Path,
+		"mth":      methods,
+		"source":   scope.Source,
+		"sourceId": scope.SourceID,
+		"db":       scope.DB,
+		"per":      string(scope.Perm),
+		"mxu":      scope.MaxUses,
+	})
+	// 不共用 getHeader 的 map，避免覆寫其他 token 的 usa
+	token.Header = map[string]interface{}{
+		"alg": j.Header.Alg,
+		"typ": j.Header.Typ,
+		"kid": j.Header.Kid,
+		"usa": UsageLink,
+	}
+	pk, err := j.getPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	ss, err := token.SignedString(pk)
+	if err != nil {
+		return nil, err
+	}
+	return &ss, nil
+}
+
+// ParseLinkToken validates the token signature, expiration and usage.
+func ParseLinkToken(j JwtToken, tokenStr string) (*LinkClaims, error) {
+	token, err := j.ParseToken(tokenStr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLinkInvalid, err)
+	}
+	if usa, _ := token.Header["usa"].(string); usa != UsageLink {
+		return nil, ErrLinkInvalid
+	}
+	mc, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrLinkInvalid
+	}
+	str := func(k string) string {
+		s, _ := mc[k].(string)
+		return s
+	}
+	exp, ok := mc["exp"].(float64)
+	if !ok || str("jti") == "" || str("path") == "" {
+		return nil, ErrLinkInvalid
+	}
+	lc := &LinkClaims{
+		Host:      str("iss"),
+		Jti:       str("jti"),
+		Path:      str("path"),
+		Source:    str("source"),
+		SourceID:  str("sourceId"),
+		DB:        str("db"),
+		Perm:      UserPerm(str("per")),
+		ExpiredAt: time.Unix(int64(exp), 0),
+	}
+	if mxu, ok := mc["mxu"].(float64); ok {
+		lc.MaxUses = int(mxu)
+	}
+	if mth, ok := mc["mth"].([]interface{}); ok {
+		for _, m := range mth {
+			if s, ok := m.(string); ok {
+				lc.Methods = append(lc.Methods, s)
+			}
+		}
+	}
+	return lc, nil
+}
+
+// MatchLinkPath reports whether path matches the link path pattern.
+func MatchLinkPath(pattern, path string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	ss := strings.Split(strings.Trim(path, "/"), "/")
+	if len(ps) != len(ss) {
+		return false
+	}
+	for i, p := range ps {
+		if p == "*" || (strings.HasPrefix(p, ":") && ss[i] != "") {
+			continue
+		}
+		if p != ss[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 未指定 Methods 的連結只能讀取
+var defaultLinkMethods = []string{http.MethodGet, http.MethodHead}
+
+// Allow checks the request method and path against the link scope.
+func (lc *LinkClaims) Allow(method, path string) bool {
+	methods := lc.Methods
+	if len(methods) == 0 {
+		methods = defaultLinkMethods
+	}
+	found := false
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			found = true
+			break
+		}
+	}
+	return found && MatchLinkPath(lc.Path, path)
+}
+
+// NewUser returns the AccessGuest used for the request made by the link.
+func (lc *LinkClaims) NewUser(ip string) AccessGuest {
+	var perm []string
+	if lc.Perm != "" {
+		perm = []string{string(lc.Perm)}
+	}
+	return NewAccessGuest(lc.Host, lc.Source, lc.SourceID, ip, "guest", lc.DB, perm)
+}
+
+type LinkAudit struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Jti      string             `bson:"jti"`
+	Source   string             `bson:"source"`
+	SourceID string             `bson:"sourceId"`
+	Method   string             `bson:"method"`
+	Path     string             `bson:"path"`
+	IP       string             `bson:"ip"`
+	// 第幾次使用
+	Count  int64     `bson:"count"`
+	UsedAt time.Time `bson:"usedAt"`
+
+	dao.CommonDoc `bson:",inline"`
+}
+
+func (a *LinkAudit) GetC() string {
+	return linkAuditC
+}
+
+func (a *LinkAudit) GetDoc() interface{} {
+	return a
+}
+
+func (a *LinkAudit) GetID() interface{} {
+	return a.ID
+}
+
+func (a *LinkAudit) GetIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "jti", Value: 1}}},
+		{Keys: bson.D{{Key: "source", Value: 1}, {Key: "sourceId", Value: 1}}},
+	}
+}
+
+type LinkService interface {
+	// Use validates the scope of the request, counts the use and writes the
+	// audit, it returns the AccessGuest of the request.
+	Use(lc *LinkClaims, method, path, ip string) (AccessGuest, error)
+	// Revoke 使連結立即失效
+	Revoke(lc *LinkClaims) error
+	ListAudit(jti string) ([]*LinkAudit, error)
+}
+
+func NewLinkService(model mgom.MgoDBModel, redis db.RedisClient) LinkService {
+	return &linkServiceImpl{
+		model: model,
+		redis: redis,
+	}
+}
+
+type linkServiceImpl struct {
+	model mgom.MgoDBModel
+	redis db.RedisClient
+}
+
+func (s *linkServiceImpl) Use(lc *LinkClaims, method, path, ip string) (AccessGuest, error) {
+	if !lc.Allow(method, path) {
+		return nil, ErrLinkScope
+	}
+	key := fmt.Sprintf(linkUseKeyTpl, lc.Jti)
+	n, err := s.redis.Incr(key)
+	if err != nil {
+		return nil, err
+	}
+	if n == 1 {
+		// 計數保留到連結過期
+		if _, err = s.redis.Expired(key, time.Until(lc.ExpiredAt)+time.Minute); err != nil {
+			return nil, err
+		}
+	}
+	if n < 0 || (lc.MaxUses > 0 && n > int64(lc.MaxUses)) {
+		return nil, ErrLinkExhausted
+	}
+	_, err = s.model.Save(&LinkAudit{
+		ID:       primitive.NewObjectID(),
+		Jti:      lc.Jti,
+		Source:   lc.Source,
+		SourceID: lc.SourceID,
+		Method:   method,
+		Path:     path,
+		IP:       ip,
+		Count:    n,
+		UsedAt:   time.Now(),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return lc.NewUser(ip), nil
+}
+
+func (s *linkServiceImpl) Revoke(lc *LinkClaims) error {
+	ttl := time.Until(lc.ExpiredAt) + time.Minute
+	if ttl <= 0 {
+		return nil
+	}
+	// 設為負數，之後的 Incr 都不會通過
+	_, err := s.redis.Set(fmt.Sprintf(linkUseKeyTpl, lc.Jti), -1<<40, ttl)
+	return err
+}
+
+func (s *linkServiceImpl) ListAudit(jti string) ([]*LinkAudit, error) {
+	result, err := s.model.Find(&LinkAudit{}, bson.M{"jti": jti})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*LinkAudit), nil
+}
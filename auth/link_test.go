@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LinkAllow(t *testing.T) {
+	assert.True(t, MatchLinkPath("/file/:id/download", "/file/abc/download"))
+	assert.True(t, MatchLinkPath("/file/*", "/file/abc/"))
+	assert.False(t, MatchLinkPath("/file/:id", "/file/abc/download"))
+	assert.False(t, MatchLinkPath("/file/abc", "/file/abcd"))
+
+	lc := &LinkClaims{Path: "/file/abc", Methods: []string{"GET"}}
+	assert.True(t, lc.Allow("get", "/file/abc"))
+	assert.False(t, lc.Allow("DELETE", "/file/abc"))
+	// 未指定 method 只能讀取
+	lc.Methods = nil
+	assert.True(t, lc.Allow("HEAD", "/file/abc"))
+	assert.False(t, lc.Allow("DELETE", "/file/abc"))
+}
@@ -0,0 +1,370 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/model/mgom"
+	"github.com/94peter/sterna/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	credentialC     = "credential"
+	loginFailKeyTpl = "login:fail:%s"
+)
+
+var (
+	ErrInvalidCredential = errors.New("invalid account or password")
+	ErrAccountLocked     = errors.New("account locked")
+	ErrAccountExists     = errors.New("account already exists")
+	ErrPasswordReused    = errors.New("password used recently")
+	ErrPasswordWeak      = errors.New("password too weak")
+)
+
+type LoginDI interface {
+	NewLoginService(model mgom.MgoDBModel, redis db.RedisClient, jwt JwtToken) LoginService
+}
+
+type PasswordConf struct {
+	// argon2id (預設) 或 bcrypt，舊演算法的 hash 會在登入時重新計算
+	Algorithm  string       `yaml:"algorithm" validate:"oneof=argon2id bcrypt"`
+	BcryptCost int          `yaml:"bcryptCost"`
+	Argon2     Argon2Params `yaml:"argon2"`
+	// 連續失敗 MaxFailures 次後鎖定 LockDuration
+	MaxFailures  int           `yaml:"maxFailures"`
+	LockDuration time.Duration `yaml:"lockDuration"`
+	// 不可與最近 HistorySize 組密碼相同
+	HistorySize int `yaml:"historySize"`
+	// token 有效分鐘數
+	TokenExp uint8 `yaml:"tokenExp"`
+	// 代理登入 token 有效分鐘數，預設 30
+	ImpersonateExp uint8 `yaml:"impersonateExp"`
+}
+
+func (conf *PasswordConf) NewPasswordHasher() PasswordHasher {
+	return NewPasswordHasher(conf.Algorithm, conf.Argon2, conf.BcryptCost)
+}
+
+func (conf *PasswordConf) NewLoginService(model mgom.MgoDBModel, redis db.RedisClient, jwt JwtToken) LoginService {
+	if conf == nil {
+		panic("password not set")
+	}
+	maxFailures := conf.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 5
+	}
+	lockDuration := conf.LockDuration
+	if lockDuration <= 0 {
+		lockDuration = 15 * time.Minute
+	}
+	impersonateExp := conf.ImpersonateExp
+	if impersonateExp == 0 {
+		impersonateExp = 30
+	}
+	hasher := conf.NewPasswordHasher()
+	return &loginServiceImpl{
+		hasher:         hasher,
+		dummyHash:      dummyHash(hasher),
+		maxFailures:    maxFailures,
+		lockDuration:   lockDuration,
+		historySize:    conf.HistorySize,
+		tokenExp:       conf.TokenExp,
+		impersonateExp: impersonateExp,
+		model:          model,
+		redis:          redis,
+		jwt:            jwt,
+	}
+}
+
+type Credential struct {
+	// Account of the user
+	ID   string   `bson:"_id"`
+	Sub  string   `bson:"sub"`
+	Name string   `bson:"name"`
+	Perm UserPerm `bson:"perm"`
+	Hash string   `bson:"hash"`
+	// 舊密碼的 hash，新的在前
+	History   []string  `bson:"history,omitempty"`
+	ChangedAt time.Time `bson:"changedAt"`
+
+	dao.CommonDoc `bson:",inline"`
+}
+
+func (c *Credential) GetC() string {
+	return credentialC
+}
+
+func (c *Credential) GetDoc() interface{} {
+	return c
+}
+
+func (c *Credential) GetID() interface{} {
+	return c.ID
+}
+
+func (c *Credential) GetIndexes() []mongo.IndexModel {
+	return nil
+}
+
+// Claims returns the token claims read by the auth middleware.
+func (c *Credential) Claims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub": c.Sub,
+		"acc": c.ID,
+		"nam": c.Name,
+		"per": string(c.Perm),
+	}
+}
+
+type LoginResult struct {
+	Token  string                 `json:"token"`
+	Claims map[string]interface{} `json:"claims"`
+}
+
+type LoginService interface {
+	Register(c *Credential, pwd string, u dao.LogUser) error
+	// Login verifies the password and issues the access token, the hash is
+	// upgraded when the hasher settings changed.
+	Login(host, account, pwd string) (*LoginResult, error)
+	ChangePassword(account, oldPwd, newPwd string, u dao.LogUser) error
+	// SetPassword 由管理者重設密碼，不需要舊密碼
+	SetPassword(account, pwd string, u dao.LogUser) error
+	Unlock(account string) error
+	Get(account string) (*Credential, error)
+	// Impersonate issues a token of account carrying actor in the act
+	// claim, admins and impersonated sessions can not be impersonated.
+	Impersonate(host, account string, actor ReqUser) (*LoginResult, error)
+}
+
+type loginServiceImpl struct {
+	hasher         PasswordHasher
+	dummyHash      string
+	maxFailures    int
+	lockDuration   time.Duration
+	historySize    int
+	tokenExp       uint8
+	impersonateExp uint8
+	model          mgom.MgoDBModel
+	redis          db.RedisClient
+	jwt            JwtToken
+}
+
+func checkPwd(pwd string) error {
+	if ok, err := util.IsValidPwd(pwd); !ok {
+		return fmt.Errorf("%w: %v", ErrPasswordWeak, err)
+	}
+	return nil
+}
+
+func (s *loginServiceImpl) Get(account string) (*Credential, error) {
+	c := &Credential{ID: account}
+	err := s.model.FindByID(c)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *loginServiceImpl) Register(c *Credential, pwd string, u dao.LogUser) error {
+	if err := checkPwd(pwd); err != nil {
+		return err
+	}
+	hash, err := s.hasher.Hash(pwd)
+	if err != nil {
+		return err
+	}
+	c.Hash = hash
+	c.History = nil
+	c.ChangedAt = time.Now()
+	_, err = s.model.Save(c, u)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAccountExists
+	}
+	return err
+}
+
+func (s *loginServiceImpl) failKey(account string) string {
+	return fmt.Sprintf(loginFailKeyTpl, account)
+}
+
+func (s *loginServiceImpl) isLocked(account string) (bool, error) {
+	key := s.failKey(account)
+	if !s.redis.Exists(key) {
+		return false, nil
+	}
+	b, err := s.redis.Get(key)
+	if err != nil {
+		return false, err
+	}
+	n, _ := strconv.Atoi(string(b))
+	return n >= s.maxFailures, nil
+}
+
+// addFailure counts the failure, the counter expires lockDuration after the
+// first failure.
+func (s *loginServiceImpl) addFailure(account string) error {
+	key := s.failKey(account)
+	n, err := s.redis.Incr(key)
+	if err != nil {
+		return err
+	}
+	if n == 1 || int(n) == s.maxFailures {
+		_, err = s.redis.Expired(key, s.lockDuration)
+	}
+	return err
+}
+
+func (s *loginServiceImpl) Login(host, account, pwd string) (*LoginResult, error) {
+	locked, err := s.isLocked(account)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		return nil, ErrAccountLocked
+	}
+	c, err := s.Get(account)
+	if err == mongo.ErrNoDocuments {
+		// 帳號不存在也驗證密碼並計入失敗次數，回應時間與次數都無法用來探測帳號
+		if s.dummyHash != "" {
+			s.hasher.Verify(s.dummyHash, pwd)
+		}
+		if err = s.addFailure(account); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredential
+	}
+	if err != nil {
+		return nil, err
+	}
+	ok, rehash, err := s.hasher.Verify(c.Hash, pwd)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err = s.addFailure(account); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredential
+	}
+	if _, err = s.redis.Del(s.failKey(account)); err != nil {
+		return nil, err
+	}
+	if rehash {
+		hash, err := s.hasher.Hash(pwd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = s.model.UpdateOne(c, bson.D{{Key: "hash", Value: hash}}, nil); err != nil {
+			return nil, err
+		}
+	}
+	claims := c.Claims()
+	token, err := s.jwt.GetToken(host, claims, s.tokenExp)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Token: *token, Claims: claims}, nil
+}
+
+// inHistory reports whether pwd matches the current or a recent password.
+func (s *loginServiceImpl) inHistory(c *Credential, pwd string) (bool, error) {
+	hashes := append([]string{c.Hash}, c.History...)
+	for _, h := range hashes {
+		ok, _, err := s.hasher.Verify(h, pwd)
+		if err != nil && err != ErrHashFormat {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *loginServiceImpl) updatePassword(c *Credential, pwd string, u dao.LogUser) error {
+	if err := checkPwd(pwd); err != nil {
+		return err
+	}
+	if s.historySize > 0 {
+		used, err := s.inHistory(c, pwd)
+		if err != nil {
+			return err
+		}
+		if used {
+			return ErrPasswordReused
+		}
+	}
+	hash, err := s.hasher.Hash(pwd)
+	if err != nil {
+		return err
+	}
+	var history []string
+	if s.historySize > 0 {
+		history = append([]string{c.Hash}, c.History...)
+		if len(history) > s.historySize {
+			history = history[:s.historySize]
+		}
+	}
+	_, err = s.model.UpdateOne(c, bson.D{
+		{Key: "hash", Value: hash},
+		{Key: "history", Value: history},
+		{Key: "changedAt", Value: time.Now()},
+	}, u)
+	return err
+}
+
+func (s *loginServiceImpl) ChangePassword(account, oldPwd, newPwd string, u dao.LogUser) error {
+	c, err := s.Get(account)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidCredential
+	}
+	if err != nil {
+		return err
+	}
+	ok, _, err := s.hasher.Verify(c.Hash, oldPwd)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCredential
+	}
+	return s.updatePassword(c, newPwd, u)
+}
+
+func (s *loginServiceImpl) SetPassword(account, pwd string, u dao.LogUser) error {
+	c, err := s.Get(account)
+	if err != nil {
+		return err
+	}
+	return s.updatePassword(c, pwd, u)
+}
+
+func (s *loginServiceImpl) Unlock(account string) error {
+	_, err := s.redis.Del(s.failKey(account))
+	return err
+}
+
+func (s *loginServiceImpl) Impersonate(host, account string, actor ReqUser) (*LoginResult, error) {
+	if IsImpersonated(actor) || actor.GetAccount() == account {
+		return nil, ErrImpersonateDenied
+	}
+	c, err := s.Get(account)
+	if err != nil {
+		return nil, err
+	}
+	if DefaultPolicy.HasRole([]string{string(c.Perm)}, PermAdmin) {
+		return nil, ErrImpersonateDenied
+	}
+	claims := SetActorClaim(c.Claims(), actor)
+	token, err := s.jwt.GetToken(host, claims, s.impersonateExp)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Token: *token, Claims: claims}, nil
+}
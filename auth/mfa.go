@@ -0,0 +1,442 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/94peter/sterna/config"
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/model/mgom"
+	"github.com/94peter/sterna/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// ClaimMfa 紀錄最後一次通過 MFA 驗證的時間 (unix)
+	ClaimMfa = "mfa"
+
+	mfaEnrollmentC = "mfa_enrollment"
+	mfaUsedKeyTpl  = "mfa:used:%s:%s"
+	mfaFailKeyTpl  = "mfa:fail:%s"
+)
+
+var (
+	ErrMfaNotEnrolled     = errors.New("mfa not enrolled")
+	ErrMfaAlreadyEnrolled = errors.New("mfa already enrolled")
+	ErrMfaInvalidCode     = errors.New("invalid mfa code")
+	ErrMfaCodeUsed        = errors.New("mfa code already used")
+	ErrMfaLocked          = errors.New("mfa locked")
+)
+
+// GenerateTotpSecret returns a random secret for NewTotp.
+func GenerateTotpSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+type MfaDI interface {
+	NewMfaService(model mgom.MgoDBModel, redis db.RedisClient) MfaService
+}
+
+type MfaConf struct {
+	Issuer string `yaml:"issuer"`
+	Period uint   `yaml:"period"`
+	// base64 編碼的 AES key (16/24/32 bytes)，用來加密儲存的 secret
+	EncryptKey    string `yaml:"encryptKey" validate:"required" secret:"true"`
+	RecoveryCodes int    `yaml:"recoveryCodes"`
+	// 連續失敗 MaxFailures 次後鎖定 LockDuration，預設 5 次 15 分鐘
+	MaxFailures  int           `yaml:"maxFailures"`
+	LockDuration time.Duration `yaml:"lockDuration"`
+}
+
+func (conf *MfaConf) String() string {
+	return config.Dump(conf)
+}
+
+func (conf *MfaConf) NewMfaService(model mgom.MgoDBModel, redis db.RedisClient) MfaService {
+	if conf == nil {
+		panic("mfa not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(conf.EncryptKey)
+	if err != nil {
+		panic("mfa encryptKey invalid: " + err.Error())
+	}
+	period := conf.Period
+	if period == 0 {
+		period = 30
+	}
+	codes := conf.RecoveryCodes
+	if codes <= 0 {
+		codes = 10
+	}
+	maxFailures := conf.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 5
+	}
+	lockDuration := conf.LockDuration
+	if lockDuration <= 0 {
+		lockDuration = 15 * time.Minute
+	}
+	return &mfaServiceImpl{
+		issuer:        conf.Issuer,
+		period:        period,
+		key:           key,
+		recoveryCodes: codes,
+		maxFailures:   maxFailures,
+		lockDuration:  lockDuration,
+		model:         model,
+		redis:         redis,
+	}
+}
+
+type MfaEnrollment struct {
+	// Account of the user
+	ID        string     `bson:"_id"`
+	Secret    string     `bson:"secret"`
+	Confirmed bool       `bson:"confirmed"`
+	ConfirmAt *time.Time `bson:"confirmAt,omitempty"`
+	// sha256 of the unused recovery codes
+	RecoveryCodes []string `bson:"recoveryCodes"`
+
+	dao.CommonDoc `bson:",inline"`
+}
+
+func (e *MfaEnrollment) GetC() string {
+	return mfaEnrollmentC
+}
+
+func (e *MfaEnrollment) GetDoc() interface{} {
+	return e
+}
+
+func (e *MfaEnrollment) GetID() interface{} {
+	return e.ID
+}
+
+func (e *MfaEnrollment) GetIndexes() []mongo.IndexModel {
+	return nil
+}
+
+type MfaService interface {
+	// Enroll creates a pending enrollment, the returned Totp is used to show
+	// the QR code, it takes effect after Confirm.
+	Enroll(u dao.LogUser) (Totp, error)
+	// Confirm activates the pending enrollment and returns the recovery codes.
+	Confirm(u dao.LogUser, code string) ([]string, error)
+	// Verify accepts a totp code or an unused recovery code, the account
+	// is locked with ErrMfaLocked after too many failures.
+	Verify(account, code string) error
+	RegenerateRecoveryCodes(u dao.LogUser) ([]string, error)
+	// Disable requires a totp code or an unused recovery code.
+	Disable(u dao.LogUser, code string) error
+	IsEnrolled(account string) (bool, error)
+}
+
+type mfaServiceImpl struct {
+	issuer        string
+	period        uint
+	key           []byte
+	recoveryCodes int
+	maxFailures   int
+	lockDuration  time.Duration
+	model         mgom.MgoDBModel
+	redis         db.RedisClient
+}
+
+func (s *mfaServiceImpl) find(account string) (*MfaEnrollment, error) {
+	e := &MfaEnrollment{ID: account}
+	err := s.model.FindByID(e)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMfaNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *mfaServiceImpl) newTotp(e *MfaEnrollment) (Totp, error) {
+	secret, err := util.AESDecrypt(s.key, e.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return NewTotp(s.issuer, e.ID, string(secret), s.period), nil
+}
+
+func (s *mfaServiceImpl) Enroll(u dao.LogUser) (Totp, error) {
+	e, err := s.find(u.GetAccount())
+	if err != nil && err != ErrMfaNotEnrolled {
+		return nil, err
+	}
+	if e != nil && e.Confirmed {
+		return nil, ErrMfaAlreadyEnrolled
+	}
+	secret, err := GenerateTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := util.AESEncrypt(s.key, []byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	e = &MfaEnrollment{
+		ID:     u.GetAccount(),
+		Secret: encrypted,
+	}
+	e.SetCreator(u)
+	if _, err = s.model.Upsert(e, u); err != nil {
+		return nil, err
+	}
+	return NewTotp(s.issuer, e.ID, secret, s.period), nil
+}
+
+func (s *mfaServiceImpl) Confirm(u dao.LogUser, code string) ([]string, error) {
+	e, err := s.find(u.GetAccount())
+	if err != nil {
+		return nil, err
+	}
+	if e.Confirmed {
+		return nil, ErrMfaAlreadyEnrolled
+	}
+	if err = s.verifyTotp(e, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := s.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	_, err = s.model.UpdateOne(e, bson.D{
+		{Key: "confirmed", Value: true},
+		{Key: "confirmAt", Value: now},
+		{Key: "recoveryCodes", Value: hashes},
+	}, u)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *mfaServiceImpl) Verify(account, code string) error {
+	e, err := s.find(account)
+	if err != nil {
+		return err
+	}
+	if !e.Confirmed {
+		return ErrMfaNotEnrolled
+	}
+	key := fmt.Sprintf(mfaFailKeyTpl, account)
+	if locked, err := s.isLocked(key); err != nil {
+		return err
+	} else if locked {
+		return ErrMfaLocked
+	}
+	err = s.verifyTotp(e, code)
+	if err == ErrMfaInvalidCode {
+		err = s.useRecoveryCode(e, code)
+	}
+	switch err {
+	case nil:
+		_, err = s.redis.Del(key)
+		return err
+	case ErrMfaInvalidCode, ErrMfaCodeUsed:
+		if ferr := s.addFailure(key); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
+
+func (s *mfaServiceImpl) isLocked(key string) (bool, error) {
+	if !s.redis.Exists(key) {
+		return false, nil
+	}
+	b, err := s.redis.Get(key)
+	if err != nil {
+		return false, err
+	}
+	n, _ := strconv.Atoi(string(b))
+	return n >= s.maxFailures, nil
+}
+
+// addFailure counts the failure, the counter expires lockDuration after the
+// first failure.
+func (s *mfaServiceImpl) addFailure(key string) error {
+	n, err := s.redis.Incr(key)
+	if err != nil {
+		return err
+	}
+	if n == 1 || int(n) == s.maxFailures {
+		_, err = s.redis.Expired(key, s.lockDuration)
+	}
+	return err
+}
+
+// verifyTotp validates the code and marks it used for the whole validation
+// window, so the same code can not be replayed.
+func (s *mfaServiceImpl) verifyTotp(e *MfaEnrollment, code string) error {
+	t, err := s.newTotp(e)
+	if err != nil {
+		return err
+	}
+	valid, err := t.ValidateCode(code)
+	if err != nil || !valid {
+		return ErrMfaInvalidCode
+	}
+	// skew 1 的有效範圍為前後各一個週期
+	window := time.Duration(s.period*3) * time.Second
+	ok, err := s.redis.SetNX(fmt.Sprintf(mfaUsedKeyTpl, e.ID, code), 1, window)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMfaCodeUsed
+	}
+	return nil
+}
+
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+func (s *mfaServiceImpl) newRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < s.recoveryCodes; i++ {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return
+}
+
+// useRecoveryCode pulls the code in one update, so concurrent requests
+// can not use or restore the same code.
+func (s *mfaServiceImpl) useRecoveryCode(e *MfaEnrollment, code string) error {
+	h := hashRecoveryCode(code)
+	result, err := s.model.GetCollection(e).UpdateOne(s.model.Context(),
+		bson.M{"_id": e.ID, "recoveryCodes": h},
+		bson.M{"$pull": bson.M{"recoveryCodes": h}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 1 {
+		return nil
+	}
+	if util.IsStrInList(h, e.RecoveryCodes...) {
+		return ErrMfaCodeUsed
+	}
+	return ErrMfaInvalidCode
+}
+
+func (s *mfaServiceImpl) RegenerateRecoveryCodes(u dao.LogUser) ([]string, error) {
+	e, err := s.find(u.GetAccount())
+	if err != nil {
+		return nil, err
+	}
+	if !e.Confirmed {
+		return nil, ErrMfaNotEnrolled
+	}
+	codes, hashes, err := s.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.model.UpdateOne(e, bson.D{{Key: "recoveryCodes", Value: hashes}}, u); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *mfaServiceImpl) Disable(u dao.LogUser, code string) error {
+	e, err := s.find(u.GetAccount())
+	if err != nil {
+		return err
+	}
+	if e.Confirmed {
+		if err = s.Verify(e.ID, code); err != nil {
+			return err
+		}
+	}
+	_, err = s.model.RemoveByID(e, u)
+	return err
+}
+
+func (s *mfaServiceImpl) IsEnrolled(account string) (bool, error) {
+	e, err := s.find(account)
+	if err == ErrMfaNotEnrolled {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return e.Confirmed, nil
+}
+
+// SetMfaClaim marks the token data as passed MFA now, it is used when
+// issuing the step-up token after MfaService.Verify.
+func SetMfaClaim(data map[string]interface{}) map[string]interface{} {
+	data[ClaimMfa] = time.Now().Unix()
+	return data
+}
+
+// MfaUser is implemented by users whose token carries the mfa claim.
+type MfaUser interface {
+	GetMfaTime() time.Time
+}
+
+// WithMfa attaches the time of the last MFA verification to u.
+func WithMfa(u ReqUser, at time.Time) ReqUser {
+	if u == nil || at.IsZero() {
+		return u
+	}
+	return &mfaReqUserImpl{
+		ReqUser: u,
+		mfaAt:   at,
+	}
+}
+
+// GetMfaTime returns the time of the last MFA verification of u.
+func GetMfaTime(u ReqUser) (time.Time, bool) {
+	if mu, ok := u.(MfaUser); ok {
+		return mu.GetMfaTime(), true
+	}
+	return time.Time{}, false
+}
+
+type mfaReqUserImpl struct {
+	ReqUser
+	mfaAt time.Time
+}
+
+func (u *mfaReqUserImpl) GetMfaTime() time.Time {
+	return u.mfaAt
+}
+
+func (u *mfaReqUserImpl) Encode() string {
+	return encodeString(u)
+}
+
+func (u *mfaReqUserImpl) Decode(data string) error {
+	d, err := decodeInto(data, u)
+	if err != nil {
+		return err
+	}
+	*u = *d.(*mfaReqUserImpl)
+	return nil
+}
@@ -14,6 +14,8 @@ type Totp interface {
 	GenerateCode() (string, error)
 	ValidateCode(code string) (valid bool, err error)
 	WriteQRCode(w io.Writer) error
+	// Uri returns the otpauth:// uri for authenticator apps.
+	Uri() (string, error)
 	ShowInfo() error
 }
 
@@ -87,6 +89,14 @@ func (tc *totpConf) WriteQRCode(w io.Writer) error {
 	return png.Encode(w, img)
 }
 
+func (tc *totpConf) Uri() (string, error) {
+	key, err := tc.generateKey()
+	if key == nil {
+		return "", err
+	}
+	return key.URL(), nil
+}
+
 func (tc *totpConf) ShowInfo() error {
 	key, err := tc.generateKey()
 	if key == nil {
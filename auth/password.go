@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+var ErrHashFormat = errors.New("unknown password hash format")
+
+type PasswordHasher interface {
+	Hash(pwd string) (string, error)
+	// Verify checks pwd against hash, rehash is true when the hash was made
+	// by another algorithm or weaker params than the current ones.
+	Verify(hash, pwd string) (ok bool, rehash bool, err error)
+}
+
+type Argon2Params struct {
+	Memory  uint32 `yaml:"memory"`
+	Time    uint32 `yaml:"time"`
+	Threads uint8  `yaml:"threads"`
+}
+
+func (p Argon2Params) withDefault() Argon2Params {
+	if p.Memory == 0 {
+		p.Memory = 64 * 1024
+	}
+	if p.Time == 0 {
+		p.Time = 1
+	}
+	if p.Threads == 0 {
+		p.Threads = 4
+	}
+	return p
+}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// NewPasswordHasher hashes with algorithm (argon2id or bcrypt) and verifies
+// hashes of both algorithms.
+func NewPasswordHasher(algorithm string, argon Argon2Params, bcryptCost int) PasswordHasher {
+	if algorithm == "" {
+		algorithm = HashArgon2id
+	}
+	if bcryptCost < bcrypt.MinCost {
+		bcryptCost = bcrypt.DefaultCost
+	}
+	return &passwordHasherImpl{
+		algorithm:  algorithm,
+		argon:      argon.withDefault(),
+		bcryptCost: bcryptCost,
+	}
+}
+
+type passwordHasherImpl struct {
+	algorithm  string
+	argon      Argon2Params
+	bcryptCost int
+}
+
+func (h *passwordHasherImpl) Hash(pwd string) (string, error) {
+	switch h.algorithm {
+	case HashBcrypt:
+		b, err := bcrypt.GenerateFromPassword([]byte(pwd), h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case HashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(pwd), salt, h.argon.Time, h.argon.Memory, h.argon.Threads, argon2KeyLen)
+		return encodeArgon2(h.argon, salt, key), nil
+	}
+	return "", fmt.Errorf("not support hash algorithm: %s", h.algorithm)
+}
+
+func (h *passwordHasherImpl) Verify(hash, pwd string) (bool, bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		p, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(pwd), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		return true, h.algorithm != HashArgon2id || p != h.argon, nil
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pwd))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, false, err
+		}
+		return true, h.algorithm != HashBcrypt || cost < h.bcryptCost, nil
+	}
+	return false, false, ErrHashFormat
+}
+
+// dummyHash returns a hash with the params of h which matches no password,
+// verifying against it takes as long as against a real hash.
+func dummyHash(h PasswordHasher) string {
+	impl, ok := h.(*passwordHasherImpl)
+	if !ok {
+		return ""
+	}
+	if impl.algorithm == HashBcrypt {
+		return fmt.Sprintf("$2a$%02d$%s", impl.bcryptCost, strings.Repeat(".", 53))
+	}
+	return encodeArgon2(impl.argon, make([]byte, argon2SaltLen), make([]byte, argon2KeyLen))
+}
+
+// encodeArgon2 uses the PHC string format,
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+func encodeArgon2(p Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2(hash string) (p Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		err = ErrHashFormat
+		return
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return
+	}
+	if version != argon2.Version {
+		err = fmt.Errorf("not support argon2 version: %d", version)
+		return
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	return
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PasswordHasher(t *testing.T) {
+	argon := NewPasswordHasher(HashArgon2id, Argon2Params{Memory: 1024}, 0)
+	hash, err := argon.Hash("Abc123!")
+	assert.Nil(t, err)
+	ok, rehash, err := argon.Verify(hash, "Abc123!")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+	ok, _, _ = argon.Verify(hash, "abc123!")
+	assert.False(t, ok)
+
+	// bcrypt 的 hash 在切換成 argon2id 後需要重新計算
+	bc := NewPasswordHasher(HashBcrypt, Argon2Params{}, 4)
+	bhash, err := bc.Hash("Abc123!")
+	assert.Nil(t, err)
+	ok, rehash, err = argon.Verify(bhash, "Abc123!")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	// argon2 參數調高後也需要重新計算
+	stronger := NewPasswordHasher(HashArgon2id, Argon2Params{Memory: 2048}, 0)
+	ok, rehash, _ = stronger.Verify(hash, "Abc123!")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	_, _, err = argon.Verify("plain", "Abc123!")
+	assert.Equal(t, ErrHashFormat, err)
+}
+
+func Test_DummyHash(t *testing.T) {
+	for _, h := range []PasswordHasher{
+		NewPasswordHasher(HashArgon2id, Argon2Params{Memory: 1024}, 0),
+		NewPasswordHasher(HashBcrypt, Argon2Params{}, 4),
+	} {
+		ok, _, err := h.Verify(dummyHash(h), "")
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	permSep      = ":"
+	permWildcard = "*"
+)
+
+// 角色層級，數字越大包含的權限越多
+var roleLevel = map[UserPerm]int{
+	PermGuest:  1,
+	PermViewer: 2,
+	PermEditor: 3,
+	PermOwner:  4,
+	PermAdmin:  5,
+}
+
+// Perm 建立 resource:action 格式的權限，例如 Perm("invoice", "write")
+func Perm(resource, action string) UserPerm {
+	return UserPerm(resource + permSep + action)
+}
+
+func (up UserPerm) IsRole() bool {
+	switch up {
+	case PermAdmin, PermMember, PermOwner, PermEditor, PermViewer, PermGuest:
+		return true
+	default:
+		return false
+	}
+}
+
+func (up UserPerm) IsPermission() bool {
+	_, _, ok := splitPerm(string(up))
+	return ok
+}
+
+// Includes reports whether the role up contains the role other in the
+// hierarchy admin ⊇ owner ⊇ editor ⊇ viewer ⊇ guest. Roles outside the
+// hierarchy only include themselves.
+func (up UserPerm) Includes(other UserPerm) bool {
+	if up == other {
+		return true
+	}
+	l, ok := roleLevel[up]
+	if !ok {
+		return false
+	}
+	ol, ok := roleLevel[other]
+	if !ok {
+		return false
+	}
+	return l >= ol
+}
+
+func splitPerm(p string) (resource, action string, ok bool) {
+	i := strings.Index(p, permSep)
+	if i <= 0 || i == len(p)-1 {
+		return "", "", false
+	}
+	return p[:i], p[i+1:], true
+}
+
+// MatchPerm reports whether the granted pattern covers perm. Both sides use
+// the resource:action format and the pattern may use "*" for either part,
+// a bare "*" matches everything.
+func MatchPerm(pattern, perm string) bool {
+	if pattern == permWildcard || pattern == perm {
+		return true
+	}
+	pr, pa, ok := splitPerm(pattern)
+	if !ok {
+		return false
+	}
+	r, a, ok := splitPerm(perm)
+	if !ok {
+		return false
+	}
+	return (pr == permWildcard || pr == r) && (pa == permWildcard || pa == a)
+}
+
+// Policy decides whether a set of user perms (roles and resource:action
+// permissions) satisfies the requirements declared on a route.
+type Policy interface {
+	Grant(role UserPerm, perms ...string) Policy
+	RolePerms(role UserPerm) []string
+	HasRole(userPerms []string, role UserPerm) bool
+	HasPermission(userPerms []string, perm string) bool
+	// Allow returns true when any of the required entries is satisfied,
+	// an empty requirement always passes.
+	Allow(userPerms []string, required []UserPerm) bool
+}
+
+// DefaultPolicy is used by the auth middlewares, admin is granted everything.
+var DefaultPolicy = NewPolicy().Grant(PermAdmin, permWildcard)
+
+func NewPolicy() Policy {
+	return &policyImpl{
+		grants: make(map[UserPerm][]string),
+	}
+}
+
+type policyImpl struct {
+	lock   sync.RWMutex
+	grants map[UserPerm][]string
+}
+
+func (p *policyImpl) Grant(role UserPerm, perms ...string) Policy {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.grants[role] = append(p.grants[role], perms...)
+	return p
+}
+
+// RolePerms returns the patterns granted to role, including the ones
+// inherited from the roles below it.
+func (p *policyImpl) RolePerms(role UserPerm) []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	var result []string
+	for r, perms := range p.grants {
+		if role.Includes(r) {
+			result = append(result, perms...)
+		}
+	}
+	return result
+}
+
+func (p *policyImpl) HasRole(userPerms []string, role UserPerm) bool {
+	for _, up := range userPerms {
+		if UserPerm(up).Includes(role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *policyImpl) HasPermission(userPerms []string, perm string) bool {
+	for _, up := range userPerms {
+		if MatchPerm(up, perm) {
+			return true
+		}
+		for _, pattern := range p.RolePerms(UserPerm(up)) {
+			if MatchPerm(pattern, perm) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *policyImpl) Allow(userPerms []string, required []UserPerm) bool {
+	if len(required) == 0 {
+		return true
+	}
+	for _, r := range required {
+		if r.IsPermission() {
+			if p.HasPermission(userPerms, string(r)) {
+				return true
+			}
+		} else if p.HasRole(userPerms, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RoleIncludes(t *testing.T) {
+	assert.True(t, PermAdmin.Includes(PermEditor))
+	assert.True(t, PermEditor.Includes(PermEditor))
+	assert.False(t, PermViewer.Includes(PermEditor))
+	assert.False(t, PermMember.Includes(PermGuest))
+	assert.True(t, PermMember.Validate())
+	assert.True(t, Perm("invoice", "write").Validate())
+	assert.False(t, UserPerm("nobody").Validate())
+}
+
+func Test_MatchPerm(t *testing.T) {
+	assert.True(t, MatchPerm("*", "invoice:write"))
+	assert.True(t, MatchPerm("invoice:*", "invoice:write"))
+	assert.True(t, MatchPerm("*:read", "invoice:read"))
+	assert.False(t, MatchPerm("invoice:read", "invoice:write"))
+	assert.False(t, MatchPerm("invoice:*", "order:write"))
+}
+
+func Test_PolicyAllow(t *testing.T) {
+	p := NewPolicy().
+		Grant(PermAdmin, "*").
+		Grant(PermEditor, "invoice:*").
+		Grant(PermViewer, "*:read")
+
+	editorOrAbove := []UserPerm{PermEditor}
+	assert.True(t, p.Allow([]string{"admin"}, editorOrAbove))
+	assert.True(t, p.Allow([]string{"owner"}, editorOrAbove))
+	assert.False(t, p.Allow([]string{"viewer"}, editorOrAbove))
+	assert.True(t, p.Allow([]string{"viewer"}, nil))
+
+	write := []UserPerm{Perm("invoice", "write")}
+	assert.True(t, p.Allow([]string{"editor"}, write))
+	// owner inherits the grants of editor
+	assert.True(t, p.Allow([]string{"owner"}, write))
+	assert.False(t, p.Allow([]string{"viewer"}, write))
+	assert.True(t, p.Allow([]string{"viewer", "invoice:write"}, write))
+	assert.True(t, p.Allow([]string{"viewer"}, []UserPerm{Perm("order", "read")}))
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// confChecker is implemented by sterna.CommonDI.
+type confChecker interface {
+	IsConfEmpty() error
+}
+
+// findKey returns the key of m equal to key, or equal ignoring case.
+func findKey(m map[string]interface{}, key string) string {
+	if _, ok := m[key]; ok {
+		return key
+	}
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
+
+// merge deep merges src into dst, values of src win.
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		k = findKey(dst, k)
+		sm, ok := v.(map[string]interface{})
+		if dm, dok := dst[k].(map[string]interface{}); ok && dok {
+			merge(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+func setPath(m map[string]interface{}, path []string, value interface{}) {
+	for i, p := range path {
+		p = findKey(m, p)
+		if i == len(path)-1 {
+			m[p] = value
+			return
+		}
+		next, ok := m[p].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[p] = next
+		}
+		m = next
+	}
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Interpolate replaces ${ENV} and ${ENV:-default} in s, an unset variable
+// without default is an error so secrets are never silently empty.
+func Interpolate(s string) (string, error) {
+	var err error
+	result := envPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := envPattern.FindStringSubmatch(m)
+		if v, ok := os.LookupEnv(sub[1]); ok {
+			return v
+		}
+		if sub[2] != "" {
+			return sub[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("env %s not set", sub[1])
+		}
+		return m
+	})
+	return result, err
+}
+
+func interpolateValue(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		return Interpolate(val)
+	case rawValue:
+		r, err := Interpolate(string(val))
+		return rawValue(r), err
+	case map[string]interface{}:
+		for k, i := range val {
+			r, err := interpolateValue(i)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			val[k] = r
+		}
+	case []interface{}:
+		for n, i := range val {
+			r, err := interpolateValue(i)
+			if err != nil {
+				return nil, err
+			}
+			val[n] = r
+		}
+	}
+	return v, nil
+}
+
+// LoadBytes merges the sources in order, interpolates the env variables
+// and returns the result as yaml.
+func LoadBytes(sources ...Source) ([]byte, error) {
+	merged := map[string]interface{}{}
+	for _, s := range sources {
+		m, err := s.Load()
+		if err != nil {
+			return nil, fmt.Errorf("load %s fail: %w", s.Name(), err)
+		}
+		merge(merged, m)
+	}
+	if _, err := interpolateValue(merged); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(merged)
+}
+
+// Load fills di from the sources, checks the validate tags and IsConfEmpty
+// when di implements it.
+func Load(di interface{}, sources ...Source) error {
+	b, err := LoadBytes(sources...)
+	if err != nil {
+		return err
+	}
+	return unmarshal(b, di)
+}
+
+func unmarshal(b []byte, di interface{}) error {
+	if err := yaml.Unmarshal(b, di); err != nil {
+		return err
+	}
+	err := Validate(di)
+	if c, ok := di.(confChecker); ok {
+		if cerr := c.IsConfEmpty(); cerr != nil {
+			ve, _ := err.(*ValidateError)
+			if ve == nil {
+				ve = &ValidateError{}
+			}
+			ve.Problems = append(ve.Problems, cerr.Error())
+			err = ve
+		}
+	}
+	return err
+}
+
+type ChangeHandler[T any] func(old, new *T)
+
+// Provider holds the current configuration of type T, usually the service
+// DI struct, and reloads it from the sources. A new config only replaces
+// the current one after it is parsed and validated.
+type Provider[T any] struct {
+	sources  []Source
+	current  atomic.Value
+	hash     [32]byte
+	lock     sync.Mutex
+	handlers []ChangeHandler[T]
+	onError  func(error)
+}
+
+// NewProvider loads the initial configuration, it fails when the sources
+// can not produce a valid config.
+func NewProvider[T any](sources ...Source) (*Provider[T], error) {
+	p := &Provider[T]{sources: sources}
+	if _, err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Provider[T]) Get() *T {
+	return p.current.Load().(*T)
+}
+
+// OnChange registers h to be called after a new config is swapped in.
+func (p *Provider[T]) OnChange(h ChangeHandler[T]) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.handlers = append(p.handlers, h)
+}
+
+// OnError registers the handler of reload errors during Watch.
+func (p *Provider[T]) OnError(h func(error)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.onError = h
+}
+
+// Reload loads the sources and swaps the config in when it changed, it
+// returns whether the config changed.
+func (p *Provider[T]) Reload() (bool, error) {
+	b, err := LoadBytes(p.sources...)
+	if err != nil {
+		return false, err
+	}
+	p.lock.Lock()
+	h := sha256.Sum256(b)
+	old, _ := p.current.Load().(*T)
+	if old != nil && h == p.hash {
+		p.lock.Unlock()
+		return false, nil
+	}
+	conf := new(T)
+	if err = unmarshal(b, conf); err != nil {
+		p.lock.Unlock()
+		return false, err
+	}
+	p.current.Store(conf)
+	p.hash = h
+	handlers := append([]ChangeHandler[T](nil), p.handlers...)
+	p.lock.Unlock()
+	// handler 可能再呼叫 Get 或 OnChange，不持有鎖
+	if old != nil {
+		for _, handler := range handlers {
+			handler(old, conf)
+		}
+	}
+	return true, nil
+}
+
+// Watch reloads the sources every interval until ctx is done. Polling works
+// for files, urls and kubernetes mounts which are swapped by symlinks.
+func (p *Provider[T]) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := p.Reload(); err != nil {
+				p.lock.Lock()
+				onError := p.onError
+				p.lock.Unlock()
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}
+}
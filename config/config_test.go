@@ -0,0 +1,89 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testMongo struct {
+	Uri  string `yaml:"uri"`
+	Pass string `yaml:"pass"`
+}
+
+type testDI struct {
+	Mongo   testMongo `yaml:"mongo"`
+	Service string    `yaml:"service"`
+	Port    int       `yaml:"port"`
+}
+
+func (d *testDI) IsConfEmpty() error {
+	if d.Service == "" {
+		return errors.New("service not set")
+	}
+	return nil
+}
+
+func Test_LoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "config.yml")
+	assert.Nil(t, os.WriteFile(f, []byte("service: demo\nport: 80\nmongo:\n  uri: mongodb://db\n  pass: ${TEST_MONGO_PASS}\n"), 0644))
+	secret := filepath.Join(dir, "secret")
+	assert.Nil(t, os.Mkdir(secret, 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(secret, "mongo.uri"), []byte("mongodb://secret\n"), 0644))
+
+	di := &testDI{}
+	err := Load(di, NewFileSource(f, false))
+	assert.Error(t, err)
+
+	t.Setenv("TEST_MONGO_PASS", "pwd")
+	t.Setenv("TESTCONF_PORT", "8080")
+	t.Setenv("TESTCONF_SERVICE", "00012345")
+	err = Load(di,
+		NewFileSource(f, false),
+		NewFileSource(filepath.Join(dir, "none.yml"), true),
+		NewDirSource(secret),
+		NewEnvSource("TESTCONF_"),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, "pwd", di.Mongo.Pass)
+	assert.Equal(t, "mongodb://secret", di.Mongo.Uri)
+	assert.Equal(t, 8080, di.Port)
+	assert.Equal(t, "00012345", di.Service)
+
+	s, err := Interpolate("${TEST_NOT_SET:-def}")
+	assert.Nil(t, err)
+	assert.Equal(t, "def", s)
+}
+
+func Test_ProviderReload(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "config.yml")
+	assert.Nil(t, os.WriteFile(f, []byte("service: v1\n"), 0644))
+	p, err := NewProvider[testDI](NewFileSource(f, false))
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", p.Get().Service)
+
+	var changed []string
+	p.OnChange(func(old, new *testDI) {
+		changed = append(changed, old.Service+">"+new.Service)
+	})
+	ok, err := p.Reload()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, os.WriteFile(f, []byte("service: v2\n"), 0644))
+	ok, err = p.Reload()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v2", p.Get().Service)
+
+	// 驗證失敗時保留舊設定
+	assert.Nil(t, os.WriteFile(f, []byte("service: \"\"\n"), 0644))
+	_, err = p.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, "v2", p.Get().Service)
+	assert.Equal(t, []string{"v1>v2"}, changed)
+}
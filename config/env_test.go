@@ -0,0 +1,58 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type envMongo struct {
+	Uri  string `yaml:"uri"`
+	Pass string `yaml:"pass" env:"TEST_MONGO_PASS"`
+}
+
+type envDI struct {
+	Mongo     *envMongo         `yaml:"mongo"`
+	Timeout   time.Duration     `yaml:"timeout"`
+	Brokers   []string          `yaml:"brokers"`
+	DbMap     map[string]int    `yaml:"dbMap"`
+	Labels    map[string]string `yaml:"labels"`
+	CacheSize int               `yaml:"cacheSize"`
+	Keys      []string          `yaml:"keys" secret:"true"`
+	Creds     map[string]string `yaml:"creds" secret:"true"`
+}
+
+func Test_BindEnv(t *testing.T) {
+	t.Setenv("SVC_MONGO_URI", "mongodb://root:pwd@db:27017")
+	t.Setenv("TEST_MONGO_PASS", "pwd")
+	t.Setenv("SVC_TIMEOUT", "3s")
+	t.Setenv("SVC_BROKERS", "k1:9092, k2:9092")
+	t.Setenv("SVC_DB_MAP", "login=1,cache=2")
+	t.Setenv("SVC_CACHE_SIZE", "100")
+
+	di := &envDI{}
+	assert.Nil(t, BindEnv(di, "SVC_"))
+	assert.Equal(t, "pwd", di.Mongo.Pass)
+	assert.Equal(t, 3*time.Second, di.Timeout)
+	assert.Equal(t, []string{"k1:9092", "k2:9092"}, di.Brokers)
+	assert.Equal(t, map[string]int{"login": 1, "cache": 2}, di.DbMap)
+	assert.Equal(t, 100, di.CacheSize)
+	assert.Nil(t, di.Labels)
+
+	dump := Dump(di)
+	assert.NotContains(t, dump, "pwd")
+	assert.Contains(t, dump, "pass: '******'")
+	assert.Contains(t, dump, "timeout: 3s")
+
+	di.Keys = []string{"k-secret"}
+	di.Creds = map[string]string{"svc": "c-secret"}
+	dump = Dump(di)
+	assert.NotContains(t, dump, "k-secret")
+	assert.NotContains(t, dump, "c-secret")
+	assert.Contains(t, dump, "keys: '******'")
+	assert.Contains(t, dump, "creds: '******'")
+
+	t.Setenv("SVC_CACHE_SIZE", "many")
+	assert.Error(t, BindEnv(di, "SVC_"))
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Source provides one layer of the configuration, later sources override
+// the earlier ones. Load returns nil when the source has nothing to offer.
+type Source interface {
+	Name() string
+	Load() (map[string]interface{}, error)
+}
+
+func parseYaml(name string, b []byte) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse %s fail: %w", name, err)
+	}
+	return m, nil
+}
+
+// NewFileSource reads a yaml file, a missing optional file is skipped.
+func NewFileSource(path string, optional bool) Source {
+	return &fileSource{path: path, optional: optional}
+}
+
+type fileSource struct {
+	path     string
+	optional bool
+}
+
+func (s *fileSource) Name() string {
+	return "file:" + s.path
+}
+
+func (s *fileSource) Load() (map[string]interface{}, error) {
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) && s.optional {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseYaml(s.Name(), b)
+}
+
+// NewUriSource reads yaml from an http url.
+func NewUriSource(uri string, timeout time.Duration) Source {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &uriSource{uri: uri, client: &http.Client{Timeout: timeout}}
+}
+
+type uriSource struct {
+	uri    string
+	client *http.Client
+}
+
+func (s *uriSource) Name() string {
+	return "uri:" + s.uri
+}
+
+func (s *uriSource) Load() (map[string]interface{}, error) {
+	resp, err := s.client.Get(s.uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("load %s fail: status %d", s.Name(), resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseYaml(s.Name(), b)
+}
+
+// NewDirSource reads a mounted directory such as a kubernetes configmap or
+// secret. Files ending with .yml or .yaml are merged as yaml, the other
+// files are single values keyed by their name, "mongo.pass" sets
+// mongo: {pass: <content>}.
+func NewDirSource(dir string) Source {
+	return &dirSource{dir: dir}
+}
+
+type dirSource struct {
+	dir string
+}
+
+func (s *dirSource) Name() string {
+	return "dir:" + s.dir
+}
+
+func (s *dirSource) Load() (map[string]interface{}, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	for _, e := range entries {
+		name := e.Name()
+		// kubernetes 以 ..data 等隱藏連結切換版本
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(s.dir, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		switch filepath.Ext(name) {
+		case ".yml", ".yaml":
+			m, err := parseYaml(path, b)
+			if err != nil {
+				return nil, err
+			}
+			merge(result, m)
+		default:
+			setPath(result, strings.Split(name, "."), rawValue(strings.TrimRight(string(b), "\r\n")))
+		}
+	}
+	return result, nil
+}
+
+// NewEnvSource overrides values by environment variables starting with
+// prefix, "__" separates the levels, SVC_MONGO__PASS with prefix "SVC_"
+// sets mongo.pass. Keys match the existing keys case-insensitively and the
+// values are kept as text until the final unmarshal, so 00012345 stays a
+// string for string fields.
+func NewEnvSource(prefix string) Source {
+	return &envSource{prefix: prefix}
+}
+
+type envSource struct {
+	prefix string
+}
+
+func (s *envSource) Name() string {
+	return "env:" + s.prefix
+}
+
+func (s *envSource) Load() (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for _, kv := range os.Environ() {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv[:i], s.prefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(kv[:i], s.prefix))
+		if key == "" {
+			continue
+		}
+		setPath(result, strings.Split(key, "__"), rawValue(kv[i+1:]))
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// rawValue is written back as a plain scalar, the target field decides its
+// type like a value written in the yaml file.
+type rawValue string
+
+func (v rawValue) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: string(v)}, nil
+}
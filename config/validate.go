@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidateError lists every problem found in the config.
+type ValidateError struct {
+	Problems []string
+}
+
+func (e *ValidateError) Error() string {
+	return "config invalid: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ValidateError) add(path, format string, a ...interface{}) {
+	e.Problems = append(e.Problems, path+": "+fmt.Sprintf(format, a...))
+}
+
+// Validate checks the validate tags of the struct pointed by v, nested
+// structs are checked when set. Supported rules, separated by comma:
+//
+//	required     non zero value, non nil pointer, non empty slice or map
+//	url          absolute url
+//	oneof=a b    one of the space separated values
+//	min=n max=n  number value, or length of string, slice and map
+//
+// The rules except required are skipped on zero values, so an empty value
+// passes oneof and means the default of the field, add required to reject it.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	e := &ValidateError{}
+	validateStruct(rv, "", e, 0)
+	if len(e.Problems) > 0 {
+		return e
+	}
+	return nil
+}
+
+func validateStruct(rv reflect.Value, prefix string, e *ValidateError, depth int) {
+	if depth > 16 {
+		return
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		key, inline := yamlKey(f)
+		if key == "" {
+			key = f.Name
+		}
+		path := prefix + key
+		if inline {
+			path = strings.TrimSuffix(prefix, ".")
+		}
+		fv := rv.Field(i)
+		if tag := f.Tag.Get("validate"); tag != "" && tag != "-" {
+			for _, rule := range strings.Split(tag, ",") {
+				validateRule(fv, path, strings.TrimSpace(rule), e)
+			}
+		}
+		nested := fv
+		if nested.Kind() == reflect.Ptr && !nested.IsNil() {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct && nested.Type() != reflect.TypeOf(time.Time{}) {
+			childPrefix := path + "."
+			if inline {
+				childPrefix = prefix
+			}
+			validateStruct(nested, childPrefix, e, depth+1)
+		}
+	}
+}
+
+func valueLen(fv reflect.Value) (float64, bool) {
+	switch fv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(fv.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), true
+	}
+	return 0, false
+}
+
+func validateRule(fv reflect.Value, path, rule string, e *ValidateError) {
+	name, param, _ := strings.Cut(rule, "=")
+	if name == "required" {
+		if fv.IsZero() || ((fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map) && fv.Len() == 0) {
+			e.add(path, "required")
+		}
+		return
+	}
+	if fv.IsZero() {
+		return
+	}
+	if fv.Kind() == reflect.Ptr {
+		fv = fv.Elem()
+	}
+	switch name {
+	case "url":
+		u, err := url.Parse(fv.String())
+		if fv.Kind() != reflect.String || err != nil || u.Scheme == "" || u.Host == "" {
+			e.add(path, "invalid url")
+		}
+	case "oneof":
+		s := fmt.Sprint(fv.Interface())
+		for _, o := range strings.Fields(param) {
+			if s == o {
+				return
+			}
+		}
+		e.add(path, "%q is not one of [%s]", s, param)
+	case "min", "max":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			e.add(path, "invalid rule %s", rule)
+			return
+		}
+		n, ok := valueLen(fv)
+		if !ok {
+			e.add(path, "rule %s not support %s", name, fv.Type())
+			return
+		}
+		if name == "min" && n < limit {
+			e.add(path, "must be at least %s", param)
+		}
+		if name == "max" && n > limit {
+			e.add(path, "must be at most %s", param)
+		}
+	default:
+		e.add(path, "unknown rule %s", rule)
+	}
+}
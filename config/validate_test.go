@@ -0,0 +1,35 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validateConf struct {
+	Uri   string   `yaml:"uri" validate:"required,url"`
+	Level string   `yaml:"level" validate:"oneof=debug info"`
+	Hosts []string `yaml:"hosts" validate:"required,max=2"`
+	Pool  int      `yaml:"pool" validate:"min=1,max=10"`
+	Redis *struct {
+		Host string `yaml:"host" validate:"required"`
+		Pass string `yaml:"pass"`
+	} `yaml:"redis"`
+}
+
+func Test_Validate(t *testing.T) {
+	conf := &validateConf{}
+	err := unmarshal([]byte("uri: localhost\nlevel: trace\npool: 20\nredis:\n  pass: x\n"), conf)
+	ve, ok := err.(*ValidateError)
+	assert.True(t, ok)
+	assert.Len(t, ve.Problems, 5)
+	msg := err.Error()
+	for _, p := range []string{"uri: invalid url", "level:", "hosts: required", "pool: must be at most 10", "redis.host: required"} {
+		assert.True(t, strings.Contains(msg, p), p)
+	}
+
+	conf = &validateConf{}
+	err = unmarshal([]byte("uri: mongodb://db:27017\nhosts: [a]\n"), conf)
+	assert.Nil(t, err)
+}
@@ -14,6 +14,14 @@ type LogUser interface {
 	GetAccount() string
 }
 
+// ActorUser is implemented by users acting on behalf of another user, the
+// record keeps both the user and the real operator.
+type ActorUser interface {
+	LogUser
+	GetActorAccount() string
+	GetActorName() string
+}
+
 type Collection interface {
 	GetC() string
 }
@@ -47,20 +55,27 @@ func NewRecord(date time.Time, acc, name, msg string) *Record {
 	}
 }
 
+// NewUserRecord 建立 u 的操作紀錄，代理操作時一併記錄實際操作者
+func NewUserRecord(date time.Time, u LogUser, msg string) *Record {
+	r := NewRecord(date, u.GetAccount(), u.GetName(), msg)
+	if au, ok := u.(ActorUser); ok {
+		r.ActorAccount = au.GetActorAccount()
+		r.ActorName = au.GetActorName()
+	}
+	return r
+}
+
 type Record struct {
-	Datetime time.Time
-	Summary  string
-	Account  string
-	Name     string
+	Datetime     time.Time
+	Summary      string
+	Account      string
+	Name         string
+	ActorAccount string `bson:",omitempty" json:",omitempty"`
+	ActorName    string `bson:",omitempty" json:",omitempty"`
 }
 
 func (c *CommonDoc) AddRecord(u LogUser, msg string) []*Record {
-	c.Records = append(c.Records, &Record{
-		Datetime: time.Now(),
-		Summary:  msg,
-		Account:  u.GetAccount(),
-		Name:     u.GetName(),
-	})
+	c.Records = append(c.Records, NewUserRecord(time.Now(), u, msg))
 	return c.Records
 }
 
@@ -68,12 +83,7 @@ func (c *CommonDoc) SetCreator(lu LogUser) {
 	if c == nil {
 		return
 	}
-	c.Records = append(c.Records, &Record{
-		Datetime: time.Now(),
-		Summary:  "create",
-		Account:  lu.GetAccount(),
-		Name:     lu.GetName(),
-	})
+	c.Records = append(c.Records, NewUserRecord(time.Now(), lu, "create"))
 }
 
 func (u *CommonDoc) GetC() string {
@@ -0,0 +1,44 @@
+package dao
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	FieldDeletedAt = "deletedAt"
+	FieldDeletedBy = "deletedBy"
+)
+
+// SoftDelete 嵌入 doc 後 (bson:",inline")，MgoDBModel 的刪除改為標記
+// deletedAt，查詢時自動排除已刪除的資料
+type SoftDelete struct {
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy string     `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
+
+func (s *SoftDelete) IsDeleted() bool {
+	return s.DeletedAt != nil
+}
+
+func (s *SoftDelete) softDelete() {}
+
+type SoftDeleteDoc interface {
+	IsDeleted() bool
+	softDelete()
+}
+
+func IsSoftDelete(c Collection) bool {
+	_, ok := c.(SoftDeleteDoc)
+	return ok
+}
+
+// NotDeleted adds the condition excluding soft deleted docs to q.
+func NotDeleted(q bson.M) bson.M {
+	cond := bson.M{FieldDeletedAt: nil}
+	if len(q) == 0 {
+		return cond
+	}
+	return bson.M{"$and": bson.A{q, cond}}
+}
@@ -0,0 +1,89 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const FieldVersion = "_v"
+
+var ErrVersionConflict = errors.New("version conflict")
+
+// Versioned 嵌入 doc 後 (bson:",inline")，更新時比對版本並遞增，版本不符
+// 回傳 VersionConflictError
+type Versioned struct {
+	Version int64 `bson:"_v" json:"_v"`
+}
+
+func (v *Versioned) GetVersion() int64 {
+	return v.Version
+}
+
+func (v *Versioned) SetVersion(ver int64) {
+	v.Version = ver
+}
+
+type VersionDoc interface {
+	GetVersion() int64
+	SetVersion(ver int64)
+}
+
+// VersionConflictError means the doc was changed by others since it was
+// read, it is output as 409 by apiErr.
+type VersionConflictError struct {
+	Collection string
+	ID         interface{}
+	Version    int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s %v: version %d conflict", e.Collection, e.ID, e.Version)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+func (e *VersionConflictError) GetStatus() int {
+	return http.StatusConflict
+}
+
+func (e *VersionConflictError) GetErrorKey() string {
+	return "version_conflict"
+}
+
+func (e *VersionConflictError) GetErrorMsg() string {
+	return "data has been modified, please reload"
+}
+
+// BatchConflictError lists the docs of a batch update whose version
+// conflicted, Err is the original write error.
+type BatchConflictError struct {
+	Conflicts []DocInter
+	Err       error
+}
+
+func (e *BatchConflictError) Error() string {
+	return fmt.Sprintf("%d docs version conflict", len(e.Conflicts))
+}
+
+func (e *BatchConflictError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BatchConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+func (e *BatchConflictError) GetStatus() int {
+	return http.StatusConflict
+}
+
+func (e *BatchConflictError) GetErrorKey() string {
+	return "version_conflict"
+}
+
+func (e *BatchConflictError) GetErrorMsg() string {
+	return e.Error()
+}
@@ -2,12 +2,14 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/94peter/sterna/config"
 	"github.com/94peter/sterna/util"
 	"github.com/gin-gonic/gin"
 
@@ -15,13 +17,22 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
-	CtxMongoKey = util.CtxKey("ctxMongoKey")
-	HeaderDBKey = "raccMongoDB"
+	CtxMongoKey     = util.CtxKey("ctxMongoKey")
+	CtxRequestIDKey = util.CtxKey("ctxRequestID")
+	CtxTxKey        = util.CtxKey("ctxMongoTx")
+	HeaderDBKey     = "raccMongoDB"
 )
 
+// GetRequestIDByCtx returns the request id set by the db middleware.
+func GetRequestIDByCtx(ctx context.Context) string {
+	id, _ := ctx.Value(CtxRequestIDKey).(string)
+	return id
+}
+
 func GetMgoDBClientByReq(req *http.Request) MongoDBClient {
 	return GetMgoDBClientByCtx(req.Context())
 }
@@ -50,14 +61,25 @@ type MongoDI interface {
 }
 
 type MongoConf struct {
-	Uri       string `yaml:"uri"`
-	User      string `yaml:"user"`
-	Pass      string `yaml:"pass"`
-	DefaultDB string `yaml:"defaul"`
+	Uri       string `yaml:"uri" env:"MONGO_URI"`
+	User      string `yaml:"user" env:"MONGO_USER"`
+	Pass      string `yaml:"pass" env:"MONGO_PASS" secret:"true"`
+	DefaultDB string `yaml:"defaul" env:"MONGO_DB"`
+
+	// 連線池設定，未設定時使用 driver 預設值
+	MaxPoolSize            uint64        `yaml:"maxPoolSize"`
+	MinPoolSize            uint64        `yaml:"minPoolSize"`
+	MaxConnIdleTime        time.Duration `yaml:"maxConnIdleTime"`
+	ConnectTimeout         time.Duration `yaml:"connectTimeout"`
+	ServerSelectionTimeout time.Duration `yaml:"serverSelectionTimeout"`
+	ReadPreference         string        `yaml:"readPreference" validate:"oneof=primary primaryPreferred secondary secondaryPreferred nearest"` // 空值為 primary
 
-	authUri  string
-	lock     *sync.Mutex
-	connPool map[string]*mongo.Client
+	authUri string
+}
+
+// String 輸出設定內容，密碼以 ****** 取代
+func (mc *MongoConf) String() string {
+	return config.Dump(mc)
 }
 
 func (mc *MongoConf) SetAuth(user, pwd string) {
@@ -76,30 +98,149 @@ func (mc *MongoConf) GetUri() string {
 	return mc.Uri
 }
 
-func (mc *MongoConf) NewMongoDBClient(ctx context.Context, userDB string) (MongoDBClient, error) {
-	if mc.Uri == "" {
-		panic("mongo uri not set")
+func (mc *MongoConf) clientOptions() (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(mc.GetUri())
+	if mc.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(mc.MaxPoolSize)
 	}
-	if mc.DefaultDB == "" {
-		panic("mongo default db not set")
+	if mc.MinPoolSize > 0 {
+		opts.SetMinPoolSize(mc.MinPoolSize)
+	}
+	if mc.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(mc.MaxConnIdleTime)
+	}
+	if mc.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(mc.ConnectTimeout)
 	}
+	if mc.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(mc.ServerSelectionTimeout)
+	}
+	if mc.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(mc.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+	return opts, opts.Validate()
+}
+
+// poolKey 相同 uri 與連線池設定共用同一個 client
+func (mc *MongoConf) poolKey() string {
+	return fmt.Sprintf("%s|%d|%d|%s|%s|%s|%s", mc.GetUri(), mc.MaxPoolSize, mc.MinPoolSize,
+		mc.MaxConnIdleTime, mc.ConnectTimeout, mc.ServerSelectionTimeout, mc.ReadPreference)
+}
 
-	client, err := mongo.NewClient(options.Client().ApplyURI(mc.GetUri()))
+var (
+	clientLock  sync.Mutex
+	clientPool  = make(map[string]*mongo.Client)
+	clientGroup singleflight.Group
+)
+
+// getClient returns the process-wide client of the conf, it connects and
+// pings once on first use. Failed connections are not cached, the connect
+// runs outside the lock and is shared by the callers of the same key.
+func (mc *MongoConf) getClient() (*mongo.Client, error) {
+	key := mc.poolKey()
+	clientLock.Lock()
+	clt, ok := clientPool[key]
+	clientLock.Unlock()
+	if ok {
+		return clt, nil
+	}
+	v, err, _ := clientGroup.Do(key, func() (interface{}, error) {
+		client, err := mc.connect()
+		if err != nil {
+			return nil, err
+		}
+		clientLock.Lock()
+		defer clientLock.Unlock()
+		// 已有其他連線先完成時，關閉這次建立的 client
+		if existing, ok := clientPool[key]; ok {
+			go client.Disconnect(context.Background())
+			return existing, nil
+		}
+		clientPool[key] = client
+		return client, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	err = client.Connect(ctx)
+	return v.(*mongo.Client), nil
+}
+
+// connectTimeout 以設定的逾時為準，未設定時與 driver 預設的 30 秒相同
+func (mc *MongoConf) connectTimeout() time.Duration {
+	timeout := mc.ServerSelectionTimeout
+	if mc.ConnectTimeout > timeout {
+		timeout = mc.ConnectTimeout
+	}
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return timeout
+}
+
+// connect 不使用請求的 ctx，client 的生命週期與請求無關。ping 使用設定的
+// read preference，primary 無法使用時 secondary 等設定仍可連線
+func (mc *MongoConf) connect() (*mongo.Client, error) {
+	opts, err := mc.clientOptions()
 	if err != nil {
-		cancel()
 		return nil, err
 	}
-	err = client.Ping(ctx, readpref.Primary())
+	client, err := mongo.NewClient(opts)
 	if err != nil {
-		cancel()
 		return nil, err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), mc.connectTimeout())
+	defer cancel()
+	if err = client.Connect(ctx); err != nil {
+		return nil, err
+	}
+	rp := opts.ReadPreference
+	if rp == nil {
+		rp = readpref.Primary()
+	}
+	if err = client.Ping(ctx, rp); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
+}
 
+// CloseMongoClients disconnects all pooled clients, call it on shutdown.
+func CloseMongoClients(ctx context.Context) error {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	var result error
+	for key, clt := range clientPool {
+		if err := clt.Disconnect(ctx); err != nil && result == nil {
+			result = err
+		}
+		delete(clientPool, key)
+	}
+	return result
+}
+
+// NewMongoDBClient returns a lightweight handle over the pooled client,
+// Close releases the handle without closing the connections.
+func (mc *MongoConf) NewMongoDBClient(ctx context.Context, userDB string) (MongoDBClient, error) {
+	if mc.Uri == "" {
+		panic("mongo uri not set")
+	}
+	if mc.DefaultDB == "" {
+		panic("mongo default db not set")
+	}
+
+	client, err := mc.getClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	return &mgoClientImpl{
 		clt:       client,
 		ctx:       ctx,
@@ -112,30 +253,121 @@ func (mc *MongoConf) NewMongoDBClient(ctx context.Context, userDB string) (Mongo
 }
 
 type mgoClientImpl struct {
-	clt     *mongo.Client
-	ctx     context.Context
-	cancel  context.CancelFunc
-	dbPool  map[string]*mongo.Database
-	session mongo.Session
+	clt    *mongo.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	dbPool map[string]*mongo.Database
 
 	defaultDB string
 	userDB    string
 }
 
-func (m *mgoClientImpl) WithSession(f func(sc mongo.SessionContext) error) error {
-	if m.session != nil {
-		return nil
+const (
+	maxTxRetries     = 3
+	maxCommitRetries = 3
+
+	labelTransientTx     = "TransientTransactionError"
+	labelUnknownTxCommit = "UnknownTransactionCommitResult"
+)
+
+func hasErrorLabel(err error, label string) bool {
+	var le interface{ HasErrorLabel(string) bool }
+	return errors.As(err, &le) && le.HasErrorLabel(label)
+}
+
+// WithTransaction runs fn in a transaction, it commits when fn returns nil
+// and aborts on error or panic. Transient transaction errors are retried, so
+// fn may run more than once. Calls made while a transaction is running join
+// it and leave the commit to the outer call. When ctx comes from WithTxHolder,
+// e.g. the request context set by the db middleware, the session is also
+// registered there and models created from that ctx join the transaction.
+func (m *mgoClientImpl) WithTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	ctx = SessionContext(ctx)
+	if sess := mongo.SessionFromContext(ctx); sess != nil {
+		return fn(mongo.NewSessionContext(ctx, sess))
 	}
+
 	session, err := m.clt.StartSession()
 	if err != nil {
-		m.cancel()
 		return err
 	}
-	if err := session.StartTransaction(); err != nil {
+	defer session.EndSession(context.Background())
+	if h, ok := ctx.Value(CtxTxKey).(*txHolder); ok {
+		h.set(session)
+		defer h.set(nil)
+	}
+	for attempt := 1; ; attempt++ {
+		err = m.runTransaction(ctx, session, fn)
+		if err == nil || attempt >= maxTxRetries || !hasErrorLabel(err, labelTransientTx) {
+			return err
+		}
+	}
+}
+
+func (m *mgoClientImpl) runTransaction(ctx context.Context, session mongo.Session, fn func(sc mongo.SessionContext) error) (err error) {
+	if err = session.StartTransaction(); err != nil {
+		return err
+	}
+	sc := mongo.NewSessionContext(ctx, session)
+	defer func() {
+		if r := recover(); r != nil {
+			session.AbortTransaction(context.Background())
+			panic(r)
+		}
+	}()
+	if err = fn(sc); err != nil {
+		session.AbortTransaction(context.Background())
 		return err
 	}
-	m.session = session
-	return mongo.WithSession(m.ctx, m.session, f)
+	for i := 1; ; i++ {
+		err = session.CommitTransaction(sc)
+		if err == nil || i >= maxCommitRetries || !hasErrorLabel(err, labelUnknownTxCommit) {
+			return err
+		}
+	}
+}
+
+// txHolder 記錄 ctx 上進行中的交易 session
+type txHolder struct {
+	lock sync.RWMutex
+	sess mongo.Session
+}
+
+func (h *txHolder) set(sess mongo.Session) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.sess = sess
+}
+
+func (h *txHolder) get() mongo.Session {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.sess
+}
+
+// WithTxHolder returns a ctx where WithTransaction registers its session,
+// so SessionContext of the ctx and its children returns the session while
+// the transaction runs. A session is not safe for concurrent use, don't
+// run other goroutines with the ctx during a transaction.
+func WithTxHolder(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(CtxTxKey).(*txHolder); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, CtxTxKey, &txHolder{})
+}
+
+// SessionContext returns ctx carrying the session of the running
+// transaction, or ctx itself when there is none.
+func SessionContext(ctx context.Context) context.Context {
+	if mongo.SessionFromContext(ctx) != nil {
+		return ctx
+	}
+	if h, ok := ctx.Value(CtxTxKey).(*txHolder); ok {
+		if sess := h.get(); sess != nil {
+			return mongo.NewSessionContext(ctx, sess)
+		}
+	}
+	return ctx
 }
 
 func (m *mgoClientImpl) GetDBList() ([]string, error) {
@@ -158,15 +390,6 @@ func (m *mgoClientImpl) Close() {
 	if m == nil {
 		return
 	}
-	if m.session != nil {
-		m.session.EndSession(m.ctx)
-	}
-	if m.clt != nil {
-		err := m.clt.Disconnect(m.ctx)
-		if err != nil {
-			fmt.Println("disconnect error: " + err.Error())
-		}
-	}
 	m.cancel()
 }
 
@@ -185,13 +408,6 @@ func (m *mgoClientImpl) GetUserDB() *mongo.Database {
 	return m.getDB(m.userDB)
 }
 
-func (m *mgoClientImpl) AbortTransaction(sc mongo.SessionContext) error {
-	return m.session.AbortTransaction(sc)
-}
-func (m *mgoClientImpl) CommitTransaction(sc mongo.SessionContext) error {
-	return m.session.CommitTransaction(sc)
-}
-
 const (
 	CoreDB = "core"
 	UserDB = "user"
@@ -200,9 +416,7 @@ const (
 type MongoDBClient interface {
 	GetCoreDB() *mongo.Database
 	GetUserDB() *mongo.Database
-	WithSession(f func(sc mongo.SessionContext) error) error
-	AbortTransaction(sc mongo.SessionContext) error
-	CommitTransaction(sc mongo.SessionContext) error
+	WithTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error
 	Close()
 	Ping() error
 }
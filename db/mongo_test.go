@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type fakeSession struct {
+	mongo.Session
+}
+
+func Test_SessionContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, ctx, SessionContext(ctx))
+
+	ctx = WithTxHolder(ctx)
+	assert.Nil(t, mongo.SessionFromContext(SessionContext(ctx)))
+
+	sess := &fakeSession{}
+	h := ctx.Value(CtxTxKey).(*txHolder)
+	h.set(sess)
+	// 之後衍生的 ctx 也取得交易中的 session
+	child := context.WithValue(ctx, CtxRequestIDKey, "1")
+	assert.Equal(t, sess, mongo.SessionFromContext(SessionContext(child)))
+
+	h.set(nil)
+	assert.Nil(t, mongo.SessionFromContext(SessionContext(child)))
+}
+
+func Test_MongoConnectOptions(t *testing.T) {
+	mc := &MongoConf{Uri: "mongodb://localhost:27017", ReadPreference: "secondary"}
+	assert.Equal(t, 30*time.Second, mc.connectTimeout())
+	mc.ConnectTimeout = 5 * time.Second
+	mc.ServerSelectionTimeout = 3 * time.Second
+	assert.Equal(t, 5*time.Second, mc.connectTimeout())
+
+	opts, err := mc.clientOptions()
+	assert.Nil(t, err)
+	assert.Equal(t, readpref.SecondaryMode, opts.ReadPreference.Mode())
+}
@@ -8,24 +8,47 @@ import (
 	"strings"
 	"time"
 
+	"github.com/94peter/sterna/config"
+	"github.com/94peter/sterna/util"
 	"github.com/go-redis/redis/v8"
 )
 
+const CtxRedisPrefixKey = util.CtxKey("ctxRedisPrefix")
+
+// WithRedisPrefix returns ctx whose redis clients created by
+// NewRedisClientDB store every key under prefix, it is set by the tenant
+// middleware.
+func WithRedisPrefix(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, CtxRedisPrefixKey, prefix)
+}
+
+func getRedisPrefix(ctx context.Context) string {
+	prefix, _ := ctx.Value(CtxRedisPrefixKey).(string)
+	return prefix
+}
+
 type RedisDI interface {
 	NewRedisClientDB(ctx context.Context, db int) (RedisClient, error)
 	GetDB(dbname string) int
 }
 
 type RedisConf struct {
-	Host  string         `yaml:"host"`
-	Pwd   string         `yaml:"pass"`
-	DbMap map[string]int `yaml:"dbMap"`
+	Host  string         `yaml:"host" env:"REDIS_HOST"`
+	Pwd   string         `yaml:"pass" env:"REDIS_PASS" secret:"true"`
+	DbMap map[string]int `yaml:"dbMap" env:"REDIS_DB_MAP"`
+}
+
+// String 輸出設定內容，密碼以 ****** 取代
+func (rc *RedisConf) String() string {
+	return config.Dump(rc)
 }
 
 func (rc *RedisConf) GetDB(dbname string) int {
 	return rc.DbMap[dbname]
 }
 
+// NewRedisClientDB connects to db, the keys are stored under the prefix of
+// ctx, see WithRedisPrefix.
 func (rc *RedisConf) NewRedisClientDB(ctx context.Context, db int) (RedisClient, error) {
 	//const connTimeout = time.Second * 5
 	var r RedisClient
@@ -45,7 +68,7 @@ func (rc *RedisConf) NewRedisClientDB(ctx context.Context, db int) (RedisClient,
 	if r.Ping() != "PONG" {
 		return nil, errors.New("redis connect error")
 	}
-	return r, nil
+	return NewPrefixRedisClient(r, getRedisPrefix(ctx)), nil
 }
 
 type RedisClient interface {
@@ -54,6 +77,8 @@ type RedisClient interface {
 	CountKeys() (int, error)
 	Get(k string) ([]byte, error)
 	Set(k string, v interface{}, exp time.Duration) (string, error)
+	SetNX(k string, v interface{}, exp time.Duration) (bool, error)
+	Incr(k string) (int64, error)
 	Del(k string) (int64, error)
 	LPush(k string, v interface{}) (int64, error)
 	RPop(k string) ([]byte, error)
@@ -116,6 +141,14 @@ func (rci *redisV8CltImpl) Set(k string, v interface{}, exp time.Duration) (stri
 	return rci.clt.Set(rci.ctx, k, v, exp).Result()
 }
 
+func (rci *redisV8CltImpl) SetNX(k string, v interface{}, exp time.Duration) (bool, error) {
+	return rci.clt.SetNX(rci.ctx, k, v, exp).Result()
+}
+
+func (rci *redisV8CltImpl) Incr(k string) (int64, error) {
+	return rci.clt.Incr(rci.ctx, k).Result()
+}
+
 func (rci *redisV8CltImpl) Del(k string) (int64, error) {
 	return rci.clt.Del(rci.ctx, k).Result()
 }
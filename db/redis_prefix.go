@@ -0,0 +1,126 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// NewPrefixRedisClient wraps clt so every key is stored under prefix,
+// it is used to keep the keys of different tenants apart in a shared db.
+func NewPrefixRedisClient(clt RedisClient, prefix string) RedisClient {
+	if prefix == "" {
+		return clt
+	}
+	// 已經以相同 prefix 包裝過
+	if pc, ok := clt.(*prefixRedisClient); ok && pc.prefix == prefix {
+		return clt
+	}
+	return &prefixRedisClient{
+		RedisClient: clt,
+		prefix:      prefix,
+	}
+}
+
+type prefixRedisClient struct {
+	RedisClient
+	prefix string
+}
+
+func (p *prefixRedisClient) key(k string) string {
+	return p.prefix + k
+}
+
+func (p *prefixRedisClient) Get(k string) ([]byte, error) {
+	return p.RedisClient.Get(p.key(k))
+}
+
+func (p *prefixRedisClient) Set(k string, v interface{}, exp time.Duration) (string, error) {
+	return p.RedisClient.Set(p.key(k), v, exp)
+}
+
+func (p *prefixRedisClient) SetNX(k string, v interface{}, exp time.Duration) (bool, error) {
+	return p.RedisClient.SetNX(p.key(k), v, exp)
+}
+
+func (p *prefixRedisClient) Incr(k string) (int64, error) {
+	return p.RedisClient.Incr(p.key(k))
+}
+
+func (p *prefixRedisClient) Del(k string) (int64, error) {
+	return p.RedisClient.Del(p.key(k))
+}
+
+func (p *prefixRedisClient) LPush(k string, v interface{}) (int64, error) {
+	return p.RedisClient.LPush(p.key(k), v)
+}
+
+func (p *prefixRedisClient) RPop(k string) ([]byte, error) {
+	return p.RedisClient.RPop(p.key(k))
+}
+
+func (p *prefixRedisClient) HGet(key string, field string) string {
+	return p.RedisClient.HGet(p.key(key), field)
+}
+
+func (p *prefixRedisClient) HSet(key string, values map[string]string) error {
+	return p.RedisClient.HSet(p.key(key), values)
+}
+
+func (p *prefixRedisClient) HGetAll(key string) map[string]string {
+	return p.RedisClient.HGetAll(p.key(key))
+}
+
+func (p *prefixRedisClient) Exists(key string) bool {
+	return p.RedisClient.Exists(p.key(key))
+}
+
+func (p *prefixRedisClient) Expired(key string, d time.Duration) (bool, error) {
+	return p.RedisClient.Expired(p.key(key), d)
+}
+
+// pattern 跳脫 prefix 中的萬用字元
+func (p *prefixRedisClient) pattern(pattern string) string {
+	var b strings.Builder
+	for _, r := range p.prefix {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + pattern
+}
+
+// CountKeys counts the keys under the prefix instead of the whole db.
+func (p *prefixRedisClient) CountKeys() (int, error) {
+	keys, err := p.RedisClient.Keys(p.pattern("*"))
+	return len(keys), err
+}
+
+func (p *prefixRedisClient) Keys(pattern string) ([]string, error) {
+	keys, err := p.RedisClient.Keys(p.pattern(pattern))
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = k[len(p.prefix):]
+	}
+	return keys, nil
+}
+
+func (p *prefixRedisClient) NewPiple() CachePipel {
+	return &prefixPipel{
+		CachePipel: p.RedisClient.NewPiple(),
+		prefix:     p.prefix,
+	}
+}
+
+type prefixPipel struct {
+	CachePipel
+	prefix string
+}
+
+func (p *prefixPipel) Get(key string) *redis.StringCmd {
+	return p.CachePipel.Get(p.prefix + key)
+}
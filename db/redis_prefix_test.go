@@ -0,0 +1,34 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type keysRedisClient struct {
+	RedisClient
+	pattern string
+}
+
+func (k *keysRedisClient) Keys(pattern string) ([]string, error) {
+	k.pattern = pattern
+	return []string{"a*:1", "a*:2"}, nil
+}
+
+func Test_PrefixRedisKeys(t *testing.T) {
+	inner := &keysRedisClient{}
+	clt := NewPrefixRedisClient(inner, "a*:")
+	assert.Equal(t, clt, NewPrefixRedisClient(clt, "a*:"))
+
+	// 只計算 prefix 下的 key，prefix 的萬用字元需跳脫
+	n, err := clt.CountKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, `a\*:*`, inner.pattern)
+
+	keys, err := clt.Keys("1")
+	assert.Nil(t, err)
+	assert.Equal(t, `a\*:1`, inner.pattern)
+	assert.Equal(t, []string{"1", "2"}, keys)
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/94peter/sterna/config"
 	"github.com/94peter/sterna/util"
 
 	yaml "gopkg.in/yaml.v3"
@@ -25,11 +26,19 @@ func InitConfByFile(f string, di interface{}) {
 	InitConfByByte(yamlFile, di)
 }
 
+// InitConfByByte 解析設定並檢查 validate tag，列出所有錯誤後 panic
 func InitConfByByte(b []byte, di interface{}) {
+	if err := LoadConfByByte(b, di); err != nil {
+		panic(err)
+	}
+}
+
+func LoadConfByByte(b []byte, di interface{}) error {
 	err := yaml.Unmarshal(b, di)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	return config.Validate(di)
 }
 
 // 初始化設定檔，讀YAML檔
@@ -49,7 +58,10 @@ func InitConfByUri(uri string, di interface{}) error {
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
-	err = yaml.Unmarshal(body, di)
+	if err != nil {
+		return err
+	}
+	err = LoadConfByByte(body, di)
 	if err != nil {
 		return err
 	}
@@ -46,11 +46,11 @@ type Storage interface {
 }
 
 type GcpConf struct {
-	CredentialsFile string `yaml:"credentailsFile"`
-	CredentailsUrl  string `yaml:"credentailsUrl"`
-	Bucket          string
-	PublicBucket    string `yaml:"publicBucket"`
-	TmpBucket       string `yaml:"tmpBucket"`
+	CredentialsFile string `yaml:"credentailsFile" env:"GCP_CREDENTIALS_FILE"`
+	CredentailsUrl  string `yaml:"credentailsUrl" env:"GCP_CREDENTIALS_URL"`
+	Bucket          string `env:"GCP_BUCKET"`
+	PublicBucket    string `yaml:"publicBucket" env:"GCP_PUBLIC_BUCKET"`
+	TmpBucket       string `yaml:"tmpBucket" env:"GCP_TMP_BUCKET"`
 }
 
 func downloadFile(filepath string, url string) error {
@@ -126,6 +126,7 @@ func (gcp *storageImpl) getClient(ctx context.Context) (*storage.Client, error)
 }
 
 func (gcp *storageImpl) Write(ctx context.Context, key string, pm Perm, writeData func(w io.Writer) error) (path string, err error) {
+	key = prefixKey(ctx, key)
 	client, err := gcp.getClient(ctx)
 	if err != nil {
 		err = fmt.Errorf("storage.NewClient: %v", err)
@@ -152,6 +153,7 @@ func (gcp *storageImpl) Write(ctx context.Context, key string, pm Perm, writeDat
 }
 
 func (gcp *storageImpl) WriteString(ctx context.Context, key string, content string, pm Perm) error {
+	key = prefixKey(ctx, key)
 	client, err := gcp.getClient(ctx)
 	if err != nil {
 		return fmt.Errorf("storage.NewClient: %v", err)
@@ -174,6 +176,7 @@ func (gcp *storageImpl) WriteString(ctx context.Context, key string, content str
 }
 
 func (gcp *storageImpl) RemoveObject(ctx context.Context, key string, pm Perm) error {
+	key = prefixKey(ctx, key)
 	client, err := gcp.getClient(ctx)
 	if err != nil {
 		return fmt.Errorf("storage.NewClient: %v", err)
@@ -193,6 +196,7 @@ func (gcp *storageImpl) RemoveObject(ctx context.Context, key string, pm Perm) e
 }
 
 func (gcp *storageImpl) OpenFile(ctx context.Context, key string, pm Perm) (io.Reader, error) {
+	key = prefixKey(ctx, key)
 	client, err := gcp.getClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("storage.NewClient: %v", err)
@@ -217,6 +221,7 @@ func (gcp *storageImpl) OpenFile(ctx context.Context, key string, pm Perm) (io.R
 }
 
 func (gcp *storageImpl) GetAttr(ctx context.Context, key string, pm Perm) (*storage.ObjectAttrs, error) {
+	key = prefixKey(ctx, key)
 	client, err := gcp.getClient(ctx)
 	if err != nil {
 		err = fmt.Errorf("storage.NewClient: %v", err)
@@ -235,6 +240,7 @@ func (gcp *storageImpl) GetPublicUrl(ctx context.Context, key string) (myurl str
 }
 
 func (gcp *storageImpl) GetDownloadUrl(ctx context.Context, key string, p Perm) (myurl string, err error) {
+	key = prefixKey(ctx, key)
 	client, err := gcp.getClient(ctx)
 	if err != nil {
 		err = fmt.Errorf("storage.NewClient: %v", err)
@@ -0,0 +1,84 @@
+package gcp
+
+import (
+	"context"
+	"io"
+	"path"
+	"time"
+
+	"github.com/94peter/sterna/util"
+
+	"cloud.google.com/go/storage"
+)
+
+const CtxStoragePrefixKey = util.CtxKey("ctxStoragePrefix")
+
+// WithStoragePrefix returns ctx whose storage calls store the objects under
+// the folder prefix, it is set by the tenant middleware. SignedURL has no
+// ctx, use NewPrefixStorage for it.
+func WithStoragePrefix(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, CtxStoragePrefixKey, prefix)
+}
+
+// prefixKey returns key under the prefix of ctx.
+func prefixKey(ctx context.Context, key string) string {
+	prefix, _ := ctx.Value(CtxStoragePrefixKey).(string)
+	if prefix == "" {
+		return key
+	}
+	return path.Join(prefix, key)
+}
+
+// NewPrefixStorage wraps s so every object key is stored under the folder
+// prefix, it is used to keep the objects of different tenants apart. The
+// prefix replaces the one of ctx.
+func NewPrefixStorage(s Storage, prefix string) Storage {
+	if prefix == "" {
+		return s
+	}
+	return &prefixStorage{
+		Storage: s,
+		prefix:  prefix,
+	}
+}
+
+type prefixStorage struct {
+	Storage
+	prefix string
+}
+
+func (p *prefixStorage) key(k string) string {
+	return path.Join(p.prefix, k)
+}
+
+func (p *prefixStorage) GetAttr(ctx context.Context, key string, pm Perm) (*storage.ObjectAttrs, error) {
+	return p.Storage.GetAttr(WithStoragePrefix(ctx, ""), p.key(key), pm)
+}
+
+func (p *prefixStorage) RemoveObject(ctx context.Context, key string, pm Perm) error {
+	return p.Storage.RemoveObject(WithStoragePrefix(ctx, ""), p.key(key), pm)
+}
+
+func (p *prefixStorage) GetDownloadUrl(ctx context.Context, key string, pm Perm) (string, error) {
+	return p.Storage.GetDownloadUrl(WithStoragePrefix(ctx, ""), p.key(key), pm)
+}
+
+func (p *prefixStorage) GetPublicUrl(ctx context.Context, object string) (string, error) {
+	return p.Storage.GetPublicUrl(WithStoragePrefix(ctx, ""), p.key(object))
+}
+
+func (p *prefixStorage) WriteString(ctx context.Context, key string, content string, pm Perm) error {
+	return p.Storage.WriteString(WithStoragePrefix(ctx, ""), p.key(key), content, pm)
+}
+
+func (p *prefixStorage) Write(ctx context.Context, key string, pm Perm, writeData func(w io.Writer) error) (string, error) {
+	return p.Storage.Write(WithStoragePrefix(ctx, ""), p.key(key), pm, writeData)
+}
+
+func (p *prefixStorage) OpenFile(ctx context.Context, key string, pm Perm) (io.Reader, error) {
+	return p.Storage.OpenFile(WithStoragePrefix(ctx, ""), p.key(key), pm)
+}
+
+func (p *prefixStorage) SignedURL(key string, contentType string, pm Perm, expDuration time.Duration) (string, error) {
+	return p.Storage.SignedURL(p.key(key), contentType, pm, expDuration)
+}
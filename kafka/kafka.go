@@ -17,7 +17,7 @@ type ConfigDI interface {
 }
 
 type KafkaConfig struct {
-	Brokers []string
+	Brokers []string `env:"KAFKA_BROKERS"`
 }
 
 func (c *KafkaConfig) NewKafkaWriter(ctx context.Context, topic string) Writer {
@@ -73,9 +73,11 @@ type LoggerDI interface {
 	NewLogger(key string) Logger
 }
 
+// LoggerConf reads level and target from yaml since they were added as
+// yaml keys, the LOG_LEVEL and LOG_TARGET env variables still override them.
 type LoggerConf struct {
-	Level     string     `yaml:"-"`
-	Target    string     `yaml:"-"`
+	Level     string     `yaml:"level" env:"LOG_LEVEL" validate:"oneof=debug info warn error fatal"`
+	Target    string     `yaml:"target" env:"LOG_TARGET" validate:"oneof=os fluent"`
 	FluentLog *fluentLog `yaml:"fluent,omitempty"`
 }
 
@@ -83,11 +85,16 @@ func (lc *LoggerConf) NewLogger(key string) Logger {
 	if lc == nil {
 		panic("log not set")
 	}
-	lc.Level = os.Getenv("LOG_LEVEL")
+	// LOG_LEVEL、LOG_TARGET 環境變數優先，未設定時使用預設值
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		lc.Level = v
+	}
 	if lc.Level == "" {
 		lc.Level = "info"
 	}
-	lc.Target = os.Getenv("LOG_TARGET")
+	if v := os.Getenv("LOG_TARGET"); v != "" {
+		lc.Target = v
+	}
 	if lc.Target == "" {
 		lc.Target = LogTargetOS
 	}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/94peter/sterna/config"
 	"github.com/94peter/sterna/log"
 
 	sendgrid "github.com/sendgrid/sendgrid-go"
@@ -23,11 +24,15 @@ type MailServ interface {
 }
 
 type SendGridConf struct {
-	ApiKey string             `yaml:"apiKey"`
+	ApiKey string             `yaml:"apiKey" env:"SENDGRID_API_KEY" secret:"true"`
 	From   *sgMail.Email      `yaml:"from"`
 	Bcc    *sgMail.BccSetting `yaml:"bcc"`
 }
 
+func (conf *SendGridConf) String() string {
+	return config.Dump(conf)
+}
+
 func (conf *SendGridConf) NewMailServ(l log.Logger) MailServ {
 	return &sendGridServ{
 		SendGridConf: conf,
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is an in-memory cache evicting the least recently used entry when
+// full, entries also expire after the ttl.
+type LRU interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	Remove(key string)
+	Len() int
+	Purge()
+}
+
+func NewLRU(size int, ttl time.Duration) LRU {
+	if size <= 0 {
+		size = 1000
+	}
+	return &lruImpl{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+		now:   time.Now,
+	}
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expired time.Time
+}
+
+type lruImpl struct {
+	lock  sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+func (c *lruImpl) Get(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if c.ttl > 0 && c.now().After(entry.expired) {
+		c.removeElement(e)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return entry.value, true
+}
+
+func (c *lruImpl) Set(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	expired := c.now().Add(c.ttl)
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		entry := e.Value.(*lruEntry)
+		entry.value = value
+		entry.expired = expired
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expired: expired})
+	if c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lruImpl) Remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *lruImpl) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ll.Len()
+}
+
+func (c *lruImpl) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+func (c *lruImpl) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.items, e.Value.(*lruEntry).key)
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LRUEvict(t *testing.T) {
+	c := NewLRU(2, time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	c.Set("c", 3)
+	// b is the least recently used
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, 2, c.Len())
+}
+
+func Test_LRUExpired(t *testing.T) {
+	c := NewLRU(2, time.Minute).(*lruImpl)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	c.Set("a", 1)
+	now = now.Add(2 * time.Minute)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
@@ -0,0 +1,272 @@
+package mgom
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuditCollection = "audit_log"
+
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditUnset   = "unset"
+	AuditUpsert  = "upsert"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+
+	// 超過筆數的批次操作只記錄條件，不比對欄位
+	auditSnapshotLimit = 1000
+)
+
+var errAuditLimit = errors.New("audit snapshot over limit")
+
+// 不列入差異比對的欄位
+var auditIgnoreFields = map[string]bool{
+	"records":        true,
+	dao.FieldVersion: true,
+}
+
+type FieldChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// AuditLog is one change of a document, written by the model when
+// EnableAudit is on.
+type AuditLog struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	Collection   string             `bson:"c" json:"collection"`
+	DocID        interface{}        `bson:"docId" json:"docId"`
+	Action       string             `bson:"action" json:"action"`
+	Changes      []*FieldChange     `bson:"changes" json:"changes"`
+	Filter       string             `bson:"filter,omitempty" json:"filter,omitempty"` // 批次筆數超過上限時取代 DocID 與 Changes
+	Account      string             `bson:"account,omitempty" json:"account,omitempty"`
+	Name         string             `bson:"name,omitempty" json:"name,omitempty"`
+	ActorAccount string             `bson:"actorAccount,omitempty" json:"actorAccount,omitempty"`
+	ActorName    string             `bson:"actorName,omitempty" json:"actorName,omitempty"`
+	RequestID    string             `bson:"requestId,omitempty" json:"requestId,omitempty"`
+	Datetime     time.Time          `bson:"datetime" json:"datetime"`
+}
+
+func (a *AuditLog) GetC() string {
+	return AuditCollection
+}
+
+func (a *AuditLog) GetDoc() interface{} {
+	return a
+}
+
+func (a *AuditLog) GetID() interface{} {
+	return a.ID
+}
+
+func (a *AuditLog) SetCreator(u dao.LogUser) {}
+
+func (a *AuditLog) AddRecord(u dao.LogUser, msg string) []*dao.Record {
+	return nil
+}
+
+func (a *AuditLog) GetIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "c", Value: 1}, {Key: "docId", Value: 1}, {Key: "datetime", Value: -1}}},
+		{Keys: bson.D{{Key: "requestId", Value: 1}}},
+	}
+}
+
+func (mm *mgoModelImpl) EnableAudit(b bool) {
+	mm.auditEnabled = b
+}
+
+type auditSnapshot struct {
+	id  interface{}
+	doc bson.M
+}
+
+// snapshot reads the docs matching filter keyed by the printed _id, it
+// returns errAuditLimit when more than auditSnapshotLimit docs match.
+func (mm *mgoModelImpl) snapshot(c string, filter bson.M) (map[string]*auditSnapshot, error) {
+	ctx := mm.context()
+	cur, err := mm.db.Collection(c).Find(ctx, filter, options.Find().SetLimit(auditSnapshotLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	result := make(map[string]*auditSnapshot)
+	for cur.Next(ctx) {
+		if len(result) >= auditSnapshotLimit {
+			return nil, errAuditLimit
+		}
+		doc := bson.M{}
+		if err = cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		result[fmt.Sprint(doc["_id"])] = &auditSnapshot{id: doc["_id"], doc: doc}
+	}
+	return result, cur.Err()
+}
+
+// diffFields compares the top level fields, a nil doc means not existing.
+func diffFields(before, after bson.M) []*FieldChange {
+	keys := make(map[string]bool)
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	var changes []*FieldChange
+	for k := range keys {
+		if k == "_id" || auditIgnoreFields[k] {
+			continue
+		}
+		b, a := before[k], after[k]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		changes = append(changes, &FieldChange{Field: k, Before: b, After: a})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// audit runs op and writes the changes of the docs matching filter. op
+// returns the filter of the changed docs when they can not be found by
+// filter before it runs, e.g. inserted docs.
+func (mm *mgoModelImpl) audit(c, action string, filter bson.M, u dao.LogUser, op func() (bson.M, error)) error {
+	if !mm.auditEnabled || c == AuditCollection {
+		_, err := op()
+		return err
+	}
+	before, err := mm.snapshot(c, filter)
+	if err == errAuditLimit {
+		if _, err = op(); err != nil {
+			return err
+		}
+		// filter 可能含 $ 開頭的欄位，以 extended json 儲存
+		b, _ := bson.MarshalExtJSON(filter, false, false)
+		if err = mm.insertAudit(mm.newAudit(c, action, u, time.Now(), func(l *AuditLog) {
+			l.Filter = string(b)
+		})); err != nil {
+			mm.log.Err(fmt.Sprintf("audit %s write fail: %s", c, err.Error()))
+		}
+		return nil
+	}
+	if err != nil {
+		mm.log.Warn(fmt.Sprintf("audit %s snapshot fail: %s", c, err.Error()))
+		_, err = op()
+		return err
+	}
+	afterFilter, err := op()
+	if err != nil {
+		return err
+	}
+	if afterFilter == nil {
+		afterFilter = filter
+		if len(before) > 0 {
+			ids := make(bson.A, 0, len(before))
+			for _, s := range before {
+				ids = append(ids, s.id)
+			}
+			afterFilter = bson.M{"_id": bson.M{"$in": ids}}
+		}
+	}
+	after, err := mm.snapshot(c, afterFilter)
+	if err != nil {
+		mm.log.Warn(fmt.Sprintf("audit %s snapshot fail: %s", c, err.Error()))
+		return nil
+	}
+	if err = mm.writeAudit(c, action, u, before, after); err != nil {
+		mm.log.Err(fmt.Sprintf("audit %s write fail: %s", c, err.Error()))
+	}
+	return nil
+}
+
+func (mm *mgoModelImpl) newAudit(c, action string, u dao.LogUser, now time.Time, set func(l *AuditLog)) *AuditLog {
+	l := &AuditLog{
+		ID:         primitive.NewObjectID(),
+		Collection: c,
+		Action:     action,
+		RequestID:  db.GetRequestIDByCtx(mm.ctx),
+		Datetime:   now,
+	}
+	if u != nil {
+		rec := dao.NewUserRecord(now, u, action)
+		l.Account, l.Name = rec.Account, rec.Name
+		l.ActorAccount, l.ActorName = rec.ActorAccount, rec.ActorName
+	}
+	set(l)
+	return l
+}
+
+func (mm *mgoModelImpl) insertAudit(logs ...interface{}) error {
+	_, err := mm.db.Collection(AuditCollection).InsertMany(mm.context(), logs)
+	return err
+}
+
+func (mm *mgoModelImpl) writeAudit(c, action string, u dao.LogUser, before, after map[string]*auditSnapshot) error {
+	now := time.Now()
+	var logs []interface{}
+	add := func(key string) {
+		var b, a bson.M
+		var id interface{}
+		if s, ok := before[key]; ok {
+			b, id = s.doc, s.id
+		}
+		if s, ok := after[key]; ok {
+			a, id = s.doc, s.id
+		}
+		changes := diffFields(b, a)
+		if len(changes) == 0 {
+			return
+		}
+		logs = append(logs, mm.newAudit(c, action, u, now, func(l *AuditLog) {
+			l.DocID, l.Changes = id, changes
+		}))
+	}
+	for key := range before {
+		add(key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			add(key)
+		}
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	return mm.insertAudit(logs...)
+}
+
+// GetHistory returns the audit logs of the doc from the newest one.
+func (mm *mgoModelImpl) GetHistory(c dao.Collection, id interface{}, limit, page int64) ([]*AuditLog, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	if page <= 0 {
+		page = 1
+	}
+	ctx := mm.context()
+	opts := options.Find().SetSort(bson.D{{Key: "datetime", Value: -1}}).
+		SetSkip(limit * (page - 1)).SetLimit(limit)
+	cur, err := mm.db.Collection(AuditCollection).Find(ctx, bson.M{"c": c.GetC(), "docId": id}, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := []*AuditLog{}
+	err = cur.All(ctx, &result)
+	return result, err
+}
@@ -0,0 +1,67 @@
+package mgom
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/94peter/sterna/log"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func Test_DiffFields(t *testing.T) {
+	before := bson.M{"_id": "1", "name": "a", "age": int32(3), "records": bson.A{}, "_v": int64(1)}
+	after := bson.M{"_id": "1", "name": "b", "age": int32(3), "email": "x", "records": bson.A{1}, "_v": int64(2)}
+	changes := diffFields(before, after)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, "email", changes[0].Field)
+	assert.Nil(t, changes[0].Before)
+	assert.Equal(t, "name", changes[1].Field)
+	assert.Equal(t, "a", changes[1].Before)
+	assert.Equal(t, "b", changes[1].After)
+
+	assert.Len(t, diffFields(before, nil), 2)
+}
+
+// Test_AuditOverLimit needs a mongo server, e.g.
+// TEST_MONGO_URI=mongodb://localhost:27017 go test ./model/mgom
+func Test_AuditOverLimit(t *testing.T) {
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI not set")
+	}
+	ctx := context.Background()
+	clt, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	assert.Nil(t, err)
+	defer clt.Disconnect(ctx)
+	db := clt.Database("sterna_test")
+	defer db.Drop(ctx)
+
+	docs := make([]interface{}, auditSnapshotLimit+1)
+	for i := range docs {
+		docs[i] = bson.M{"_id": fmt.Sprint(i), "name": "a"}
+	}
+	_, err = db.Collection("repo").InsertMany(ctx, docs)
+	assert.Nil(t, err)
+
+	model := NewMgoModel(ctx, db, (&log.LoggerConf{}).NewLogger("test"))
+	model.EnableAudit(true)
+	n, err := model.UpdateAll(&repoDoc{}, bson.M{"name": "a"}, bson.D{{Key: "name", Value: "b"}}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(auditSnapshotLimit+1), n)
+
+	// 超過上限只寫一筆記錄條件的 log
+	var logs []*AuditLog
+	cur, err := db.Collection(AuditCollection).Find(ctx, bson.M{"c": "repo"})
+	assert.Nil(t, err)
+	assert.Nil(t, cur.All(ctx, &logs))
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, AuditUpdate, logs[0].Action)
+		assert.Nil(t, logs[0].DocID)
+		assert.Contains(t, logs[0].Filter, `"name":"a"`)
+	}
+}
@@ -0,0 +1,216 @@
+package mgom
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetCursorPaginationSource returns a keyset pagination source sorted by
+// sortField then _id, the sort field should be indexed together with _id.
+func (mm *mgoModelImpl) GetCursorPaginationSource(d dao.DocInter, q bson.M, sortField string, desc bool) util.CursorSource {
+	return &mongoCursorPaginationImpl{
+		mm:        mm,
+		d:         d,
+		q:         q,
+		sortField: sortField,
+		desc:      desc,
+	}
+}
+
+// GetPipeCursorPaginationSource is the aggregate version, the sort field is
+// read from the output of the pipeline.
+func (mm *mgoModelImpl) GetPipeCursorPaginationSource(aggr MgoAggregate, q bson.M, sortField string, desc bool) util.CursorSource {
+	return &mongoCursorPaginationImpl{
+		mm:        mm,
+		d:         aggr,
+		aggr:      aggr,
+		q:         q,
+		sortField: sortField,
+		desc:      desc,
+	}
+}
+
+type mongoCursorPaginationImpl struct {
+	mm        *mgoModelImpl
+	d         dao.Collection
+	aggr      MgoAggregate
+	q         bson.M
+	sortField string
+	desc      bool
+}
+
+type cursorKey struct {
+	Value bson.RawValue `bson:"v"`
+	ID    bson.RawValue `bson:"i"`
+}
+
+func encodeCursorKey(raw bson.Raw, sortField string) (string, error) {
+	id, err := raw.LookupErr("_id")
+	if err != nil {
+		return "", err
+	}
+	key := &cursorKey{ID: id}
+	if sortField != "_id" {
+		// 排序欄位不存在時以 null 處理
+		v, err := raw.LookupErr(strings.Split(sortField, ".")...)
+		if err != nil {
+			v = bson.RawValue{Type: bson.TypeNull}
+		}
+		key.Value = v
+	}
+	b, err := bson.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursorKey(s string) (*cursorKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, util.ErrInvalidCursor
+	}
+	key := &cursorKey{}
+	if err = bson.Unmarshal(b, key); err != nil || key.ID.Type == 0 {
+		return nil, util.ErrInvalidCursor
+	}
+	return key, nil
+}
+
+// keysetFilter returns the condition of the rows after key in the direction,
+// ties of the sort field are broken by _id. Null and missing values sort
+// before all others, they are handled apart since $gt and $lt only compare
+// values of the same type.
+func keysetFilter(sortField string, key *cursorKey, after bool) bson.M {
+	op := "$lt"
+	if after {
+		op = "$gt"
+	}
+	if sortField == "_id" {
+		return bson.M{"_id": bson.M{op: key.ID}}
+	}
+	if key.Value.Type == bson.TypeNull || key.Value.Type == bson.TypeUndefined || key.Value.Type == 0 {
+		tie := bson.M{sortField: nil, "_id": bson.M{op: key.ID}}
+		if !after {
+			return tie
+		}
+		return bson.M{"$or": bson.A{bson.M{sortField: bson.M{"$ne": nil}}, tie}}
+	}
+	cond := bson.A{
+		bson.M{sortField: bson.M{op: key.Value}},
+		bson.M{sortField: key.Value, "_id": bson.M{op: key.ID}},
+	}
+	if !after {
+		cond = append(cond, bson.M{sortField: nil})
+	}
+	return bson.M{"$or": cond}
+}
+
+func keysetSort(sortField string, asc bool) bson.D {
+	dir := -1
+	if asc {
+		dir = 1
+	}
+	if sortField == "_id" {
+		return bson.D{{Key: "_id", Value: dir}}
+	}
+	return bson.D{{Key: sortField, Value: dir}, {Key: "_id", Value: dir}}
+}
+
+func (mpi *mongoCursorPaginationImpl) Count() (int64, error) {
+	if mpi.aggr != nil {
+		return mpi.mm.CountAggrDocuments(mpi.aggr, mpi.q)
+	}
+	return mpi.mm.CountDocuments(mpi.d, mpi.q)
+}
+
+func (mpi *mongoCursorPaginationImpl) cursor(key string, backward bool, limit int64) (*mongo.Cursor, error) {
+	asc := mpi.desc == backward
+	sort := keysetSort(mpi.sortField, asc)
+	var keyset bson.M
+	if key != "" {
+		k, err := decodeCursorKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keyset = keysetFilter(mpi.sortField, k, asc)
+	}
+	ctx := mpi.mm.context()
+	collection := mpi.mm.db.Collection(mpi.d.GetC())
+	if mpi.aggr != nil {
+		pl := mpi.mm.pipeline(mpi.aggr, mpi.q)
+		if keyset != nil {
+			pl = append(pl, bson.D{{Key: "$match", Value: keyset}})
+		}
+		pl = append(pl, bson.D{{Key: "$sort", Value: sort}}, bson.D{{Key: "$limit", Value: limit}})
+		return collection.Aggregate(ctx, pl)
+	}
+	filter := mpi.mm.Filter(mpi.d, mpi.q)
+	if keyset != nil {
+		filter = bson.M{"$and": bson.A{filter, keyset}}
+	}
+	return collection.Find(ctx, filter, options.Find().SetSort(sort).SetLimit(limit))
+}
+
+func (mpi *mongoCursorPaginationImpl) CursorData(
+	key string, backward bool, limit int64,
+	format func(i interface{}) map[string]interface{},
+) (*util.CursorPage, error) {
+	if format == nil {
+		return nil, errors.New("format is nil")
+	}
+	// 多取一筆判斷是否還有資料
+	cur, err := mpi.cursor(key, backward, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	ctx := mpi.mm.context()
+	defer cur.Close(ctx)
+	var raws []bson.Raw
+	for cur.Next(ctx) {
+		raws = append(raws, append(bson.Raw{}, cur.Current...))
+	}
+	if err = cur.Err(); err != nil {
+		return nil, err
+	}
+	page := &util.CursorPage{HasMore: int64(len(raws)) > limit}
+	if page.HasMore {
+		raws = raws[:limit]
+	}
+	if backward {
+		for i, j := 0, len(raws)-1; i < j; i, j = i+1, j-1 {
+			raws[i], raws[j] = raws[j], raws[i]
+		}
+	}
+	if len(raws) == 0 {
+		return page, nil
+	}
+	if page.First, err = encodeCursorKey(raws[0], mpi.sortField); err != nil {
+		return nil, err
+	}
+	if page.Last, err = encodeCursorKey(raws[len(raws)-1], mpi.sortField); err != nil {
+		return nil, err
+	}
+	docType := reflect.TypeOf(mpi.d)
+	if docType.Kind() == reflect.Ptr {
+		docType = docType.Elem()
+	}
+	for _, raw := range raws {
+		doc := reflect.New(docType).Interface()
+		if err = bson.Unmarshal(raw, doc); err != nil {
+			return nil, err
+		}
+		if data := format(doc); data != nil {
+			page.Rows = append(page.Rows, data)
+		}
+	}
+	return page, nil
+}
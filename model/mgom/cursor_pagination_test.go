@@ -0,0 +1,50 @@
+package mgom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_CursorKey(t *testing.T) {
+	raw, _ := bson.Marshal(bson.M{"_id": "a1", "info": bson.M{"score": 9}})
+	s, err := encodeCursorKey(raw, "info.score")
+	assert.Nil(t, err)
+	key, err := decodeCursorKey(s)
+	assert.Nil(t, err)
+	assert.Equal(t, "a1", key.ID.StringValue())
+	assert.Equal(t, int32(9), key.Value.Int32())
+
+	f := keysetFilter("info.score", key, true)
+	assert.Len(t, f["$or"], 2)
+	assert.Equal(t, bson.D{{Key: "info.score", Value: -1}, {Key: "_id", Value: -1}}, keysetSort("info.score", false))
+
+	_, err = decodeCursorKey("bad")
+	assert.NotNil(t, err)
+}
+
+func Test_CursorKeyNull(t *testing.T) {
+	raw, _ := bson.Marshal(bson.M{"_id": "a1"})
+	s, err := encodeCursorKey(raw, "score")
+	assert.Nil(t, err)
+	key, err := decodeCursorKey(s)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.TypeNull, key.Value.Type)
+
+	// null 排在最前面，往後是同為 null 的較大 _id 與所有非 null
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"score": bson.M{"$ne": nil}},
+		bson.M{"score": nil, "_id": bson.M{"$gt": key.ID}},
+	}}, keysetFilter("score", key, true))
+	assert.Equal(t, bson.M{"score": nil, "_id": bson.M{"$lt": key.ID}}, keysetFilter("score", key, false))
+
+	// 遞減時非 null 之後接著 null
+	raw, _ = bson.Marshal(bson.M{"_id": "a2", "score": 3})
+	s, _ = encodeCursorKey(raw, "score")
+	key, _ = decodeCursorKey(s)
+	f := keysetFilter("score", key, false)
+	assert.Len(t, f["$or"], 3)
+	assert.Equal(t, bson.M{"score": nil}, f["$or"].(bson.A)[2])
+	assert.Len(t, keysetFilter("score", key, true)["$or"], 2)
+}
@@ -32,6 +32,16 @@ type MgoDBModel interface {
 	Save(d dao.DocInter, u dao.LogUser) (interface{}, error)
 	RemoveAll(d dao.DocInter, q primitive.M, u dao.LogUser) (int64, error)
 	RemoveByID(d dao.DocInter, u dao.LogUser) (int64, error)
+	// 以下用於嵌入 dao.SoftDelete 的 doc
+	Restore(d dao.DocInter, u dao.LogUser) (int64, error)
+	PurgeDeleted(d dao.DocInter, before time.Time) (int64, error)
+	WithDeleted() MgoDBModel
+	Filter(c dao.Collection, q bson.M) bson.M
+	// EnableAudit 開啟後寫入與刪除會在 audit_log 記錄欄位差異
+	EnableAudit(b bool)
+	GetHistory(c dao.Collection, id interface{}, limit, page int64) ([]*AuditLog, error)
+	// 嵌入 dao.Versioned 的 doc 在 UpdateOne、Upsert、BatchUpdate 時比對版本，
+	// 不符時回傳 dao.VersionConflictError 或 dao.BatchConflictError
 	UpdateOne(d dao.DocInter, fields bson.D, u dao.LogUser) (int64, error)
 	UpdateAll(d dao.DocInter, q bson.M, fields bson.D, u dao.LogUser) (int64, error)
 	UnsetFields(d dao.DocInter, q bson.M, fields []string, u dao.LogUser) (int64, error)
@@ -57,12 +67,20 @@ type MgoDBModel interface {
 	CountDocuments(d dao.Collection, q bson.M) (int64, error)
 	GetPaginationSource(d dao.DocInter, q bson.M, opts ...*options.FindOptions) util.PaginationSource
 	GetPipePaginationSource(aggr MgoAggregate, q bson.M, sort bson.M) util.PaginationSource
+	// keyset 分頁，依 sortField 與 _id 排序
+	GetCursorPaginationSource(d dao.DocInter, q bson.M, sortField string, desc bool) util.CursorSource
+	GetPipeCursorPaginationSource(aggr MgoAggregate, q bson.M, sortField string, desc bool) util.CursorSource
 
 	CreateCollection(dlist ...dao.DocInter) error
 	//Reference to customer code, use for aggregate pagination
 	CountAggrDocuments(aggr MgoAggregate, q bson.M) (int64, error)
 	GetPipeMatchPaginationSource(aggr MgoAggregate, q bson.M, sort bson.M) util.PaginationSource
 
+	// GetCollection and Context are used by Repository
+	GetCollection(c dao.Collection) *mongo.Collection
+	Context() context.Context
+	WithContext(ctx context.Context) MgoDBModel
+
 	NewFindMgoDS(d dao.DocInter, q bson.M, opts ...*options.FindOptions) MgoDS
 	NewPipeFindMgoDS(d MgoAggregate, q bson.M, opts ...*options.AggregateOptions) MgoDS
 }
@@ -105,6 +123,8 @@ func NewMgoModelByReq(req *http.Request, source string) MgoDBModel {
 	}
 }
 
+var ErrNotSoftDelete = errors.New("doc not support soft delete")
+
 func GetObjectID(id interface{}) (primitive.ObjectID, error) {
 	switch dtype := reflect.TypeOf(id).String(); dtype {
 	case "string":
@@ -119,6 +139,8 @@ func GetObjectID(id interface{}) (primitive.ObjectID, error) {
 
 type mgoModelImpl struct {
 	disableCheckBeforeSave bool
+	withDeleted            bool
+	auditEnabled           bool
 	db                     *mongo.Database
 	log                    log.Logger
 	ctx                    context.Context
@@ -134,6 +156,27 @@ func (mm *mgoModelImpl) SetDB(db *mongo.Database) {
 	mm.db = db
 }
 
+func (mm *mgoModelImpl) GetCollection(c dao.Collection) *mongo.Collection {
+	return mm.db.Collection(c.GetC())
+}
+
+func (mm *mgoModelImpl) Context() context.Context {
+	return mm.context()
+}
+
+// WithContext returns a copy of the model running with ctx.
+func (mm *mgoModelImpl) WithContext(ctx context.Context) MgoDBModel {
+	m := *mm
+	m.ctx = ctx
+	return &m
+}
+
+// context carries the session of the transaction running on the model
+// context, see db.WithTxHolder.
+func (mm *mgoModelImpl) context() context.Context {
+	return db.SessionContext(mm.ctx)
+}
+
 func (mm *mgoModelImpl) FindAndExec(
 	d dao.DocInter, q bson.M,
 	exec func(i interface{}) error,
@@ -141,17 +184,18 @@ func (mm *mgoModelImpl) FindAndExec(
 ) error {
 	var err error
 	collection := mm.db.Collection(d.GetC())
-	sortCursor, err := collection.Find(mm.ctx, q, opts...)
+	sortCursor, err := collection.Find(mm.context(), mm.Filter(d, q), opts...)
 	if err != nil {
-		return nil
+		return err
 	}
+	defer sortCursor.Close(mm.context())
 	val := reflect.ValueOf(d)
 	if val.Kind() == reflect.Ptr {
 		val = reflect.Indirect(val)
 	}
 	var newValue reflect.Value
 	var newDoc dao.DocInter
-	for sortCursor.Next(mm.ctx) {
+	for sortCursor.Next(mm.context()) {
 		newValue = reflect.New(val.Type())
 		newDoc = newValue.Interface().(dao.DocInter)
 		err = sortCursor.Decode(newDoc)
@@ -163,6 +207,9 @@ func (mm *mgoModelImpl) FindAndExec(
 			return err
 		}
 	}
+	if err = sortCursor.Err(); err != nil {
+		return err
+	}
 	w2 := reflect.ValueOf(newValue)
 	if w2.IsZero() {
 		return nil
@@ -176,7 +223,7 @@ func (mm *mgoModelImpl) FindAndExec(
 
 func (mm *mgoModelImpl) CountDocuments(d dao.Collection, q bson.M) (int64, error) {
 	opts := options.Count().SetMaxTime(2 * time.Second)
-	return mm.db.Collection(d.GetC()).CountDocuments(mm.ctx, q, opts)
+	return mm.db.Collection(d.GetC()).CountDocuments(mm.context(), mm.Filter(d, q), opts)
 }
 
 func (mm *mgoModelImpl) isCollectExisted(d dao.DocInter) bool {
@@ -215,24 +262,49 @@ func (mm *mgoModelImpl) BatchUpdate(doclist []dao.DocInter, getField func(d dao.
 	}
 	collection := mm.db.Collection(doclist[0].GetC())
 	var operations []mongo.WriteModel
+	versioned := false
 	for _, d := range doclist {
 		op := mongo.NewUpdateOneModel()
 
-		op.SetFilter(bson.M{"_id": d.GetID()})
-		op.SetUpdate(bson.D{
+		filter := bson.M{"_id": d.GetID()}
+		update := bson.D{
 			{Key: "$set", Value: getField(d)},
-		})
+		}
+		if vd, ok := d.(dao.VersionDoc); ok {
+			filter, update = withVersion(vd, filter, update)
+			versioned = true
+		}
+		op.SetFilter(filter)
+		op.SetUpdate(update)
 		op.SetUpsert(true)
 		operations = append(operations, op)
 	}
-	bulkOption := options.BulkWriteOptions{}
-	_, err = collection.BulkWrite(mm.ctx, operations, &bulkOption)
+	// 有版本的 doc 不依序執行，每一筆的衝突都能回報
+	bulkOption := options.BulkWrite().SetOrdered(!versioned)
+	_, err = collection.BulkWrite(mm.context(), operations, bulkOption)
 
+	failedIdx := make(map[int]bool)
+	var conflicts []dao.DocInter
 	if excep, ok := err.(mongo.BulkWriteException); ok {
 		for _, e := range excep.WriteErrors {
-			failed = append(failed, doclist[e.Index])
+			d := doclist[e.Index]
+			failed = append(failed, d)
+			failedIdx[e.Index] = true
+			if vd, ok := d.(dao.VersionDoc); ok && e.Code == duplicateKeyCode && mm.isConflict(d, vd) {
+				conflicts = append(conflicts, d)
+			}
+		}
+	}
+	if err == nil || len(failedIdx) > 0 {
+		for i, d := range doclist {
+			if vd, ok := d.(dao.VersionDoc); ok && !failedIdx[i] {
+				vd.SetVersion(vd.GetVersion() + 1)
+			}
 		}
 	}
+	if len(conflicts) > 0 {
+		err = &dao.BatchConflictError{Conflicts: conflicts, Err: err}
+	}
 	return
 }
 
@@ -257,7 +329,7 @@ func (mm *mgoModelImpl) BatchSave(doclist []dao.DocInter, u dao.LogUser) (insert
 		batch = append(batch, d)
 	}
 	var result *mongo.InsertManyResult
-	result, err = collection.InsertMany(mm.ctx, batch, &options.InsertManyOptions{Ordered: &ordered})
+	result, err = collection.InsertMany(mm.context(), batch, &options.InsertManyOptions{Ordered: &ordered})
 	if result != nil {
 		inserted = result.InsertedIDs
 	}
@@ -270,7 +342,72 @@ func (mm *mgoModelImpl) BatchSave(doclist []dao.DocInter, u dao.LogUser) (insert
 	return
 }
 
-func (mm *mgoModelImpl) Save(d dao.DocInter, u dao.LogUser) (interface{}, error) {
+func (mm *mgoModelImpl) Save(d dao.DocInter, u dao.LogUser) (id interface{}, err error) {
+	err = mm.audit(d.GetC(), AuditCreate, bson.M{"_id": d.GetID()}, u, func() (bson.M, error) {
+		id, err = mm.save(d, u)
+		return bson.M{"_id": id}, err
+	})
+	return
+}
+
+func (mm *mgoModelImpl) RemoveAll(d dao.DocInter, q primitive.M, u dao.LogUser) (n int64, err error) {
+	err = mm.audit(d.GetC(), AuditDelete, q, u, func() (bson.M, error) {
+		n, err = mm.removeAll(d, q, u)
+		return nil, err
+	})
+	return
+}
+
+func (mm *mgoModelImpl) RemoveByID(d dao.DocInter, u dao.LogUser) (n int64, err error) {
+	err = mm.audit(d.GetC(), AuditDelete, bson.M{"_id": d.GetID()}, u, func() (bson.M, error) {
+		n, err = mm.removeByID(d, u)
+		return nil, err
+	})
+	return
+}
+
+// Restore clears the soft delete mark of d.
+func (mm *mgoModelImpl) Restore(d dao.DocInter, u dao.LogUser) (n int64, err error) {
+	err = mm.audit(d.GetC(), AuditRestore, bson.M{"_id": d.GetID()}, u, func() (bson.M, error) {
+		n, err = mm.restore(d, u)
+		return nil, err
+	})
+	return
+}
+
+func (mm *mgoModelImpl) UpdateOne(d dao.DocInter, fields bson.D, u dao.LogUser) (n int64, err error) {
+	err = mm.audit(d.GetC(), AuditUpdate, mm.Filter(d, bson.M{"_id": d.GetID()}), u, func() (bson.M, error) {
+		n, err = mm.updateOne(d, fields, u)
+		return nil, err
+	})
+	return
+}
+
+func (mm *mgoModelImpl) UpdateAll(d dao.DocInter, q bson.M, fields bson.D, u dao.LogUser) (n int64, err error) {
+	err = mm.audit(d.GetC(), AuditUpdate, mm.Filter(d, q), u, func() (bson.M, error) {
+		n, err = mm.updateAll(d, q, fields, u)
+		return nil, err
+	})
+	return
+}
+
+func (mm *mgoModelImpl) UnsetFields(d dao.DocInter, q bson.M, fields []string, u dao.LogUser) (n int64, err error) {
+	err = mm.audit(d.GetC(), AuditUnset, mm.Filter(d, q), u, func() (bson.M, error) {
+		n, err = mm.unsetFields(d, q, fields, u)
+		return nil, err
+	})
+	return
+}
+
+func (mm *mgoModelImpl) Upsert(d dao.DocInter, u dao.LogUser) (id interface{}, err error) {
+	err = mm.audit(d.GetC(), AuditUpsert, bson.M{"_id": d.GetID()}, u, func() (bson.M, error) {
+		id, err = mm.upsert(d, u)
+		return bson.M{"_id": d.GetID()}, err
+	})
+	return
+}
+
+func (mm *mgoModelImpl) save(d dao.DocInter, u dao.LogUser) (interface{}, error) {
 	if !mm.disableCheckBeforeSave {
 		err := mm.CreateCollection(d)
 		if err != nil {
@@ -283,7 +420,7 @@ func (mm *mgoModelImpl) Save(d dao.DocInter, u dao.LogUser) (interface{}, error)
 	}
 	collection := mm.db.Collection(d.GetC())
 
-	result, err := collection.InsertOne(mm.ctx, d.GetDoc())
+	result, err := collection.InsertOne(mm.context(), d.GetDoc())
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
@@ -291,56 +428,172 @@ func (mm *mgoModelImpl) Save(d dao.DocInter, u dao.LogUser) (interface{}, error)
 
 }
 
-func (mm *mgoModelImpl) RemoveAll(d dao.DocInter, q primitive.M, u dao.LogUser) (int64, error) {
+// softDeleteUpdate 標記刪除並附加操作紀錄
+func softDeleteUpdate(u dao.LogUser, rec *dao.Record) bson.M {
+	set := bson.M{dao.FieldDeletedAt: time.Now()}
+	updated := bson.M{"$set": set}
+	if u != nil {
+		set[dao.FieldDeletedBy] = u.GetAccount()
+	}
+	if rec != nil {
+		updated["$push"] = bson.M{"records": rec}
+	}
+	return updated
+}
+
+func (mm *mgoModelImpl) removeAll(d dao.DocInter, q primitive.M, u dao.LogUser) (int64, error) {
 	collection := mm.db.Collection(d.GetC())
-	result, err := collection.DeleteMany(mm.ctx, q)
-	return result.DeletedCount, err
+	if dao.IsSoftDelete(d) {
+		var rec *dao.Record
+		if u != nil {
+			rec = dao.NewUserRecord(time.Now(), u, "deleted")
+		}
+		result, err := collection.UpdateMany(mm.context(), dao.NotDeleted(q), softDeleteUpdate(u, rec))
+		if result != nil {
+			return result.ModifiedCount, err
+		}
+		return 0, err
+	}
+	result, err := collection.DeleteMany(mm.context(), q)
+	if result != nil {
+		return result.DeletedCount, err
+	}
+	return 0, err
 }
 
-func (mm *mgoModelImpl) RemoveByID(d dao.DocInter, u dao.LogUser) (int64, error) {
+func (mm *mgoModelImpl) removeByID(d dao.DocInter, u dao.LogUser) (int64, error) {
 	collection := mm.db.Collection(d.GetC())
-	result, err := collection.DeleteOne(mm.ctx, bson.M{"_id": d.GetID()})
-	return result.DeletedCount, err
+	if dao.IsSoftDelete(d) {
+		var rec *dao.Record
+		if u != nil {
+			if recs := d.AddRecord(u, "deleted"); len(recs) > 0 {
+				rec = recs[len(recs)-1]
+			}
+		}
+		result, err := collection.UpdateOne(mm.context(),
+			dao.NotDeleted(bson.M{"_id": d.GetID()}), softDeleteUpdate(u, rec))
+		if result != nil {
+			return result.ModifiedCount, err
+		}
+		return 0, err
+	}
+	result, err := collection.DeleteOne(mm.context(), bson.M{"_id": d.GetID()})
+	if result != nil {
+		return result.DeletedCount, err
+	}
+	return 0, err
 }
 
-func (mm *mgoModelImpl) UpdateOne(d dao.DocInter, fields bson.D, u dao.LogUser) (int64, error) {
+// restore clears the soft delete mark of d.
+func (mm *mgoModelImpl) restore(d dao.DocInter, u dao.LogUser) (int64, error) {
+	if !dao.IsSoftDelete(d) {
+		return 0, ErrNotSoftDelete
+	}
+	updated := bson.M{"$unset": bson.M{dao.FieldDeletedAt: "", dao.FieldDeletedBy: ""}}
 	if u != nil {
-		fields = append(fields, primitive.E{Key: "records", Value: d.AddRecord(u, "updated")})
+		if recs := d.AddRecord(u, "restored"); len(recs) > 0 {
+			updated["$push"] = bson.M{"records": recs[len(recs)-1]}
+		}
 	}
 	collection := mm.db.Collection(d.GetC())
-	result, err := collection.UpdateOne(mm.ctx, bson.M{"_id": d.GetID()},
-		bson.D{
-			{Key: "$set", Value: fields},
-		},
-	)
+	result, err := collection.UpdateOne(mm.context(),
+		bson.M{"_id": d.GetID(), dao.FieldDeletedAt: bson.M{"$ne": nil}}, updated)
 	if result != nil {
 		return result.ModifiedCount, err
 	}
 	return 0, err
 }
 
-func (mm *mgoModelImpl) UpdateAll(d dao.DocInter, q bson.M, fields bson.D, u dao.LogUser) (int64, error) {
+// PurgeDeleted removes the docs soft deleted before the time.
+func (mm *mgoModelImpl) PurgeDeleted(d dao.DocInter, before time.Time) (int64, error) {
+	if !dao.IsSoftDelete(d) {
+		return 0, ErrNotSoftDelete
+	}
+	collection := mm.db.Collection(d.GetC())
+	result, err := collection.DeleteMany(mm.context(), bson.M{dao.FieldDeletedAt: bson.M{"$lt": before}})
+	if result != nil {
+		return result.DeletedCount, err
+	}
+	return 0, err
+}
+
+// WithDeleted returns a model whose queries include soft deleted docs.
+func (mm *mgoModelImpl) WithDeleted() MgoDBModel {
+	m := *mm
+	m.withDeleted = true
+	return &m
+}
+
+// Filter adds the soft delete condition to q when c uses dao.SoftDelete.
+func (mm *mgoModelImpl) Filter(c dao.Collection, q bson.M) bson.M {
+	if mm.withDeleted || !dao.IsSoftDelete(c) {
+		return q
+	}
+	return dao.NotDeleted(q)
+}
+
+// pipeline prepends the soft delete condition to the pipeline when aggr
+// uses dao.SoftDelete. Pipelines which must start with another stage, e.g.
+// $geoNear, need WithDeleted and their own condition.
+func (mm *mgoModelImpl) pipeline(aggr MgoAggregate, q bson.M) mongo.Pipeline {
+	pl := aggr.GetPipeline(q)
+	if mm.withDeleted || !dao.IsSoftDelete(aggr) {
+		return pl
+	}
+	return append(mongo.Pipeline{{{Key: "$match", Value: dao.NotDeleted(nil)}}}, pl...)
+}
+
+func (mm *mgoModelImpl) updateOne(d dao.DocInter, fields bson.D, u dao.LogUser) (int64, error) {
+	if u != nil {
+		fields = append(fields, primitive.E{Key: "records", Value: d.AddRecord(u, "updated")})
+	}
+	filter := mm.Filter(d, bson.M{"_id": d.GetID()})
+	update := bson.D{
+		{Key: "$set", Value: fields},
+	}
+	vd, versioned := d.(dao.VersionDoc)
+	if versioned {
+		filter, update = withVersion(vd, filter, update)
+	}
+	collection := mm.db.Collection(d.GetC())
+	result, err := collection.UpdateOne(mm.context(), filter, update)
+	if result == nil {
+		return 0, err
+	}
+	if versioned && err == nil {
+		if result.MatchedCount == 0 {
+			if mm.isConflict(d, vd) {
+				return 0, conflictErr(d, vd)
+			}
+			return 0, nil
+		}
+		vd.SetVersion(vd.GetVersion() + 1)
+	}
+	return result.ModifiedCount, err
+}
+
+func (mm *mgoModelImpl) updateAll(d dao.DocInter, q bson.M, fields bson.D, u dao.LogUser) (int64, error) {
 	updated := bson.D{
 		{Key: "$set", Value: fields},
 	}
 	if u != nil {
-		updated = append(updated, primitive.E{Key: "$push", Value: primitive.M{"records": dao.NewRecord(time.Now(), u.GetAccount(), u.GetName(), "updated")}})
+		updated = append(updated, primitive.E{Key: "$push", Value: primitive.M{"records": dao.NewUserRecord(time.Now(), u, "updated")}})
 	}
 	collection := mm.db.Collection(d.GetC())
-	result, err := collection.UpdateMany(mm.ctx, q, updated)
+	result, err := collection.UpdateMany(mm.context(), mm.Filter(d, q), updated)
 	if result != nil {
 		return result.ModifiedCount, err
 	}
 	return 0, err
 }
 
-func (mm *mgoModelImpl) UnsetFields(d dao.DocInter, q bson.M, fields []string, u dao.LogUser) (int64, error) {
+func (mm *mgoModelImpl) unsetFields(d dao.DocInter, q bson.M, fields []string, u dao.LogUser) (int64, error) {
 	collection := mm.db.Collection(d.GetC())
 	m := primitive.M{}
 	for _, k := range fields {
 		m[k] = ""
 	}
-	result, err := collection.UpdateMany(mm.ctx, q,
+	result, err := collection.UpdateMany(mm.context(), mm.Filter(d, q),
 		bson.D{
 			{Key: "$unset", Value: m},
 		},
@@ -351,14 +604,31 @@ func (mm *mgoModelImpl) UnsetFields(d dao.DocInter, q bson.M, fields []string, u
 	return 0, err
 }
 
-func (mm *mgoModelImpl) Upsert(d dao.DocInter, u dao.LogUser) (interface{}, error) {
+func (mm *mgoModelImpl) upsert(d dao.DocInter, u dao.LogUser) (interface{}, error) {
 	err := mm.CreateCollection(d)
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
 
 	collection := mm.db.Collection(d.GetC())
-	_, err = collection.UpdateOne(mm.ctx, bson.M{"_id": d.GetID()}, bson.M{"$set": d.GetDoc()}, options.Update().SetUpsert(true))
+	if vd, ok := d.(dao.VersionDoc); ok {
+		doc, err := docWithoutVersion(d)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		filter, update := withVersion(vd, bson.M{"_id": d.GetID()}, bson.D{{Key: "$set", Value: doc}})
+		_, err = collection.UpdateOne(mm.context(), filter, update, options.Update().SetUpsert(true))
+		// 版本不符時 upsert 會以相同 _id 新增而重複
+		if mongo.IsDuplicateKeyError(err) && mm.isConflict(d, vd) {
+			return primitive.NilObjectID, conflictErr(d, vd)
+		}
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		vd.SetVersion(vd.GetVersion() + 1)
+		return d.GetID(), nil
+	}
+	_, err = collection.UpdateOne(mm.context(), bson.M{"_id": d.GetID()}, bson.M{"$set": d.GetDoc()}, options.Update().SetUpsert(true))
 
 	if err != nil {
 		return primitive.NilObjectID, err
@@ -378,18 +648,18 @@ func (mm *mgoModelImpl) FindOne(d dao.DocInter, q bson.M, option ...*options.Fin
 		return errors.New("doc is nil")
 	}
 	collection := mm.db.Collection(d.GetC())
-	return collection.FindOne(mm.ctx, q, option...).Decode(d)
+	return collection.FindOne(mm.context(), mm.Filter(d, q), option...).Decode(d)
 }
 
 func (mm *mgoModelImpl) Find(d dao.DocInter, q bson.M, option ...*options.FindOptions) (interface{}, error) {
 	myType := reflect.TypeOf(d)
 	slice := reflect.MakeSlice(reflect.SliceOf(myType), 0, 0).Interface()
 	collection := mm.db.Collection(d.GetC())
-	sortCursor, err := collection.Find(mm.ctx, q, option...)
+	sortCursor, err := collection.Find(mm.context(), mm.Filter(d, q), option...)
 	if err != nil {
 		return nil, err
 	}
-	err = sortCursor.All(mm.ctx, &slice)
+	err = sortCursor.All(mm.context(), &slice)
 	if err != nil {
 		return nil, err
 	}
@@ -400,11 +670,11 @@ func (mm *mgoModelImpl) PipeFind(aggr MgoAggregate, filter bson.M, opts ...*opti
 	myType := reflect.TypeOf(aggr)
 	slice := reflect.MakeSlice(reflect.SliceOf(myType), 0, 0).Interface()
 	collection := mm.db.Collection(aggr.GetC())
-	sortCursor, err := collection.Aggregate(mm.ctx, aggr.GetPipeline(filter), opts...)
+	sortCursor, err := collection.Aggregate(mm.context(), mm.pipeline(aggr, filter), opts...)
 	if err != nil {
 		return nil, err
 	}
-	err = sortCursor.All(mm.ctx, &slice)
+	err = sortCursor.All(mm.context(), &slice)
 	if err != nil {
 		return nil, err
 	}
@@ -413,17 +683,18 @@ func (mm *mgoModelImpl) PipeFind(aggr MgoAggregate, filter bson.M, opts ...*opti
 
 func (mm *mgoModelImpl) PipeFindAndExec(aggr MgoAggregate, filter bson.M, exec func(i interface{}) error, opts ...*options.AggregateOptions) error {
 	collection := mm.db.Collection(aggr.GetC())
-	sortCursor, err := collection.Aggregate(mm.ctx, aggr.GetPipeline(filter), opts...)
+	sortCursor, err := collection.Aggregate(mm.context(), mm.pipeline(aggr, filter), opts...)
 	if err != nil {
 		return err
 	}
+	defer sortCursor.Close(mm.context())
 	val := reflect.ValueOf(aggr)
 	if val.Kind() == reflect.Ptr {
 		val = reflect.Indirect(val)
 	}
 	var newValue reflect.Value
 	var newDoc dao.DocInter
-	for sortCursor.Next(mm.ctx) {
+	for sortCursor.Next(mm.context()) {
 		newValue = reflect.New(val.Type())
 		newDoc = newValue.Interface().(dao.DocInter)
 		err = sortCursor.Decode(newDoc)
@@ -435,6 +706,9 @@ func (mm *mgoModelImpl) PipeFindAndExec(aggr MgoAggregate, filter bson.M, exec f
 			return err
 		}
 	}
+	if err = sortCursor.Err(); err != nil {
+		return err
+	}
 
 	w2 := reflect.ValueOf(newValue)
 	if w2.IsZero() {
@@ -449,11 +723,12 @@ func (mm *mgoModelImpl) PipeFindAndExec(aggr MgoAggregate, filter bson.M, exec f
 
 func (mm *mgoModelImpl) PipeFindOne(aggr MgoAggregate, filter bson.M) error {
 	collection := mm.db.Collection(aggr.GetC())
-	sortCursor, err := collection.Aggregate(mm.ctx, aggr.GetPipeline(filter))
+	sortCursor, err := collection.Aggregate(mm.context(), mm.pipeline(aggr, filter))
 	if err != nil {
 		return err
 	}
-	if sortCursor.Next(mm.ctx) {
+	defer sortCursor.Close(mm.context())
+	if sortCursor.Next(mm.context()) {
 		err = sortCursor.Decode(aggr)
 		if err != nil {
 			return err
@@ -475,12 +750,12 @@ func (mm *mgoModelImpl) PageFind(d dao.DocInter, filter bson.M, limit, page int6
 	myType := reflect.TypeOf(d)
 	slice := reflect.MakeSlice(reflect.SliceOf(myType), 0, 0).Interface()
 	collection := mm.db.Collection(d.GetC())
-	sortCursor, err := collection.Find(mm.ctx, filter, opts...)
+	sortCursor, err := collection.Find(mm.context(), mm.Filter(d, filter), opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	err = sortCursor.All(mm.ctx, &slice)
+	err = sortCursor.All(mm.context(), &slice)
 	return slice, err
 }
 
@@ -496,12 +771,12 @@ func (mm *mgoModelImpl) PagePipeFind(aggr MgoAggregate, filter bson.M, sort bson
 	slice := reflect.MakeSlice(reflect.SliceOf(myType), 0, 0).Interface()
 
 	collection := mm.db.Collection(aggr.GetC())
-	pl := append(aggr.GetPipeline(filter), bson.D{{Key: "$sort", Value: sort}}, bson.D{{Key: "$skip", Value: skip}}, bson.D{{Key: "$limit", Value: limit}})
-	sortCursor, err := collection.Aggregate(mm.ctx, pl)
+	pl := append(mm.pipeline(aggr, filter), bson.D{{Key: "$sort", Value: sort}}, bson.D{{Key: "$skip", Value: skip}}, bson.D{{Key: "$limit", Value: limit}})
+	sortCursor, err := collection.Aggregate(mm.context(), pl)
 	if err != nil {
 		return nil, err
 	}
-	err = sortCursor.All(mm.ctx, &slice)
+	err = sortCursor.All(mm.context(), &slice)
 	if err != nil {
 		return nil, err
 	}
@@ -512,7 +787,7 @@ func (mm *mgoModelImpl) PagePipeFind(aggr MgoAggregate, filter bson.M, sort bson
 
 func (mm *mgoModelImpl) AggrCountDocuments(aggr MgoAggregate, q bson.M) (int64, error) {
 	opts := options.Count().SetMaxTime(2 * time.Second)
-	return mm.db.Collection(aggr.GetC()).CountDocuments(mm.ctx, q, opts)
+	return mm.db.Collection(aggr.GetC()).CountDocuments(mm.context(), mm.Filter(aggr, q), opts)
 }
 
 type countMgoAggregate struct {
@@ -521,13 +796,14 @@ type countMgoAggregate struct {
 
 func (mm *mgoModelImpl) CountAggrDocuments(aggr MgoAggregate, q bson.M) (int64, error) {
 	collection := mm.db.Collection(aggr.GetC())
-	pl := append(aggr.GetPipeline(q), bson.D{{Key: "$count", Value: "count"}})
-	sortCursor, err := collection.Aggregate(mm.ctx, pl)
+	pl := append(mm.pipeline(aggr, q), bson.D{{Key: "$count", Value: "count"}})
+	sortCursor, err := collection.Aggregate(mm.context(), pl)
 	if err != nil {
 		return 0, err
 	}
+	defer sortCursor.Close(mm.context())
 	var obj countMgoAggregate
-	if sortCursor.Next(mm.ctx) {
+	if sortCursor.Next(mm.context()) {
 		err = sortCursor.Decode(&obj)
 		if err != nil {
 			return 0, err
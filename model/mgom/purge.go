@@ -0,0 +1,82 @@
+package mgom
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/log"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DBLister returns the databases to purge, e.g. the user dbs of the tenants.
+type DBLister func(ctx context.Context, clt db.MongoDBClient) ([]*mongo.Database, error)
+
+// DBNames lists the databases by name, an empty name is the core db.
+func DBNames(names ...string) DBLister {
+	return func(ctx context.Context, clt db.MongoDBClient) ([]*mongo.Database, error) {
+		result := make([]*mongo.Database, 0, len(names))
+		for _, name := range names {
+			if name == "" {
+				result = append(result, clt.GetCoreDB())
+				continue
+			}
+			result = append(result, clt.GetCoreDB().Client().Database(name))
+		}
+		return result, nil
+	}
+}
+
+// NewPurgeRunner removes the docs soft deleted longer than retention from
+// the databases of list every interval, nil list purges the core db. It
+// runs until ctx is done, e.g. app.NewWorker("purge", NewPurgeRunner(...)),
+// failures are logged and retried next time.
+func NewPurgeRunner(
+	di db.MongoDI, l log.Logger, list DBLister,
+	retention, interval time.Duration, docs ...dao.DocInter,
+) func(ctx context.Context) error {
+	if list == nil {
+		list = DBNames("")
+	}
+	purge := func(ctx context.Context) error {
+		clt, err := di.NewMongoDBClient(ctx, "")
+		if err != nil {
+			return err
+		}
+		defer clt.Close()
+		dbs, err := list(ctx, clt)
+		if err != nil {
+			return err
+		}
+		before := time.Now().Add(-retention)
+		for _, database := range dbs {
+			model := NewMgoModel(ctx, database, l)
+			for _, d := range docs {
+				n, err := model.PurgeDeleted(d, before)
+				if err != nil {
+					return fmt.Errorf("purge %s.%s: %w", database.Name(), d.GetC(), err)
+				}
+				if n > 0 {
+					l.Info(fmt.Sprintf("purge %s.%s: %d removed", database.Name(), d.GetC(), n))
+				}
+			}
+		}
+		return nil
+	}
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := purge(ctx); err != nil && ctx.Err() == nil {
+				l.Err(err.Error())
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package mgom
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/94peter/sterna/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Query builds the filter and the find options, e.g.
+//
+//	q := NewQuery().Eq("state", "open").Range("price", 10, nil).Sort("price", false).Limit(20)
+//	model.Find(d, q.Filter(), q.FindOptions())
+type Query interface {
+	Eq(field string, v interface{}) Query
+	Ne(field string, v interface{}) Query
+	In(field string, values ...interface{}) Query
+	// Range 為 gte <= field < lt，nil 表示不限制
+	Range(field string, gte, lt interface{}) Query
+	Regex(field, pattern, opts string) Query
+	Exists(field string, b bool) Query
+	And(qs ...Query) Query
+	Or(qs ...Query) Query
+	Sort(field string, asc bool) Query
+	Project(fields ...string) Query
+	Limit(n int64) Query
+
+	Filter() bson.M
+	// Match returns the $match stage of the filter for GetPipeline.
+	Match() bson.D
+	FindOptions() *options.FindOptions
+}
+
+func NewQuery() Query {
+	return &queryImpl{}
+}
+
+type queryImpl struct {
+	conds   []bson.M
+	sort    bson.D
+	project bson.M
+	limit   int64
+}
+
+func (q *queryImpl) add(field string, cond interface{}) Query {
+	q.conds = append(q.conds, bson.M{field: cond})
+	return q
+}
+
+func (q *queryImpl) Eq(field string, v interface{}) Query {
+	return q.add(field, v)
+}
+
+func (q *queryImpl) Ne(field string, v interface{}) Query {
+	return q.add(field, bson.M{"$ne": v})
+}
+
+func (q *queryImpl) In(field string, values ...interface{}) Query {
+	return q.add(field, bson.M{"$in": bson.A(values)})
+}
+
+func (q *queryImpl) Range(field string, gte, lt interface{}) Query {
+	cond := bson.M{}
+	if gte != nil {
+		cond["$gte"] = gte
+	}
+	if lt != nil {
+		cond["$lt"] = lt
+	}
+	if len(cond) == 0 {
+		return q
+	}
+	return q.add(field, cond)
+}
+
+func (q *queryImpl) Regex(field, pattern, opts string) Query {
+	return q.add(field, primitive.Regex{Pattern: pattern, Options: opts})
+}
+
+func (q *queryImpl) Exists(field string, b bool) Query {
+	return q.add(field, bson.M{"$exists": b})
+}
+
+func filters(qs []Query) bson.A {
+	result := make(bson.A, 0, len(qs))
+	for _, sub := range qs {
+		if f := sub.Filter(); len(f) > 0 {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func (q *queryImpl) And(qs ...Query) Query {
+	if fs := filters(qs); len(fs) > 0 {
+		q.conds = append(q.conds, bson.M{"$and": fs})
+	}
+	return q
+}
+
+func (q *queryImpl) Or(qs ...Query) Query {
+	if fs := filters(qs); len(fs) > 0 {
+		q.conds = append(q.conds, bson.M{"$or": fs})
+	}
+	return q
+}
+
+func (q *queryImpl) Sort(field string, asc bool) Query {
+	dir := -1
+	if asc {
+		dir = 1
+	}
+	q.sort = append(q.sort, bson.E{Key: field, Value: dir})
+	return q
+}
+
+func (q *queryImpl) Project(fields ...string) Query {
+	if q.project == nil {
+		q.project = bson.M{}
+	}
+	for _, f := range fields {
+		q.project[f] = 1
+	}
+	return q
+}
+
+func (q *queryImpl) Limit(n int64) Query {
+	q.limit = n
+	return q
+}
+
+// Filter returns the single condition as is, more conditions are joined
+// with $and.
+func (q *queryImpl) Filter() bson.M {
+	switch len(q.conds) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return q.conds[0]
+	}
+	all := make(bson.A, len(q.conds))
+	for i, c := range q.conds {
+		all[i] = c
+	}
+	return bson.M{"$and": all}
+}
+
+func (q *queryImpl) Match() bson.D {
+	return bson.D{{Key: "$match", Value: q.Filter()}}
+}
+
+func (q *queryImpl) FindOptions() *options.FindOptions {
+	opts := options.Find()
+	if len(q.sort) > 0 {
+		opts.SetSort(q.sort)
+	}
+	if len(q.project) > 0 {
+		opts.SetProjection(q.project)
+	}
+	if q.limit > 0 {
+		opts.SetLimit(q.limit)
+	}
+	return opts
+}
+
+var ErrInvalidParam = errors.New("invalid query param")
+
+type ParamType int
+
+const (
+	ParamString ParamType = iota
+	ParamInt
+	ParamFloat
+	ParamBool
+	ParamTime // RFC3339 或 2006-01-02
+	ParamObjectID
+)
+
+const (
+	ParamOpEq       = "eq"
+	ParamOpIn       = "in"       // 逗號分隔
+	ParamOpRange    = "range"    // 讀取 <name>_from 與 <name>_to，包含兩端
+	ParamOpContains = "contains" // 不分大小寫，輸入會跳脫
+	ParamOpPrefix   = "prefix"
+
+	maxInValues = 100
+)
+
+// ParamRule maps a query parameter to a field, the value is parsed as Type
+// so it can never become an operator.
+type ParamRule struct {
+	Field string
+	Type  ParamType
+	Op    string
+}
+
+// ParamFilter translates whitelisted http query parameters into a Query,
+// the other parameters are ignored. The "sort" parameter lists the fields
+// of SortFields separated by comma, with "-" for descending.
+type ParamFilter struct {
+	Rules      map[string]*ParamRule
+	SortFields []string
+}
+
+func parseParam(t ParamType, s string) (interface{}, error) {
+	switch t {
+	case ParamString:
+		return s, nil
+	case ParamInt:
+		return strconv.ParseInt(s, 10, 64)
+	case ParamFloat:
+		return strconv.ParseFloat(s, 64)
+	case ParamBool:
+		return strconv.ParseBool(s)
+	case ParamTime:
+		if tm, err := time.Parse(time.RFC3339, s); err == nil {
+			return tm, nil
+		}
+		return time.Parse("2006-01-02", s)
+	case ParamObjectID:
+		return primitive.ObjectIDFromHex(s)
+	}
+	return nil, fmt.Errorf("unknown param type %d", t)
+}
+
+func (pf *ParamFilter) Parse(values url.Values) (Query, error) {
+	q := &queryImpl{}
+	// 依名稱排序，條件順序固定
+	names := make([]string, 0, len(pf.Rules))
+	for name := range pf.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := pf.apply(q, name, pf.Rules[name], values); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range strings.Split(values.Get("sort"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		field := strings.TrimPrefix(s, "-")
+		if !util.IsStrInList(field, pf.SortFields...) {
+			return nil, fmt.Errorf("%w: sort %s", ErrInvalidParam, field)
+		}
+		q.Sort(field, !strings.HasPrefix(s, "-"))
+	}
+	return q, nil
+}
+
+func (pf *ParamFilter) apply(q *queryImpl, name string, rule *ParamRule, values url.Values) error {
+	parse := func(key, s string) (interface{}, error) {
+		v, err := parseParam(rule.Type, s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidParam, key)
+		}
+		return v, nil
+	}
+	switch rule.Op {
+	case ParamOpRange:
+		cond := bson.M{}
+		for _, b := range [][2]string{{name + "_from", "$gte"}, {name + "_to", "$lte"}} {
+			key, op := b[0], b[1]
+			s := values.Get(key)
+			if s == "" {
+				continue
+			}
+			v, err := parse(key, s)
+			if err != nil {
+				return err
+			}
+			cond[op] = v
+		}
+		if len(cond) > 0 {
+			q.add(rule.Field, cond)
+		}
+		return nil
+	}
+	s := values.Get(name)
+	if s == "" {
+		return nil
+	}
+	switch rule.Op {
+	case ParamOpIn:
+		parts := strings.Split(s, ",")
+		if len(parts) > maxInValues {
+			return fmt.Errorf("%w: %s too many values", ErrInvalidParam, name)
+		}
+		list := make([]interface{}, 0, len(parts))
+		for _, p := range parts {
+			v, err := parse(name, strings.TrimSpace(p))
+			if err != nil {
+				return err
+			}
+			list = append(list, v)
+		}
+		q.In(rule.Field, list...)
+	case ParamOpContains:
+		q.Regex(rule.Field, regexp.QuoteMeta(s), "i")
+	case ParamOpPrefix:
+		q.Regex(rule.Field, "^"+regexp.QuoteMeta(s), "")
+	default:
+		v, err := parse(name, s)
+		if err != nil {
+			return err
+		}
+		q.Eq(rule.Field, v)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package mgom
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func Test_Query(t *testing.T) {
+	q := NewQuery().Eq("state", "open")
+	assert.Equal(t, bson.M{"state": "open"}, q.Filter())
+
+	q.Range("price", 10, nil).Or(NewQuery().Exists("tag", true), NewQuery().In("type", "a", "b")).
+		Sort("price", false).Project("name").Limit(5)
+	assert.Equal(t, bson.M{"$and": bson.A{
+		bson.M{"state": "open"},
+		bson.M{"price": bson.M{"$gte": 10}},
+		bson.M{"$or": bson.A{
+			bson.M{"tag": bson.M{"$exists": true}},
+			bson.M{"type": bson.M{"$in": bson.A{"a", "b"}}},
+		}},
+	}}, q.Filter())
+	opts := q.FindOptions()
+	assert.Equal(t, int64(5), *opts.Limit)
+	assert.Equal(t, bson.D{{Key: "price", Value: -1}}, opts.Sort)
+}
+
+func Test_ParamFilter(t *testing.T) {
+	pf := &ParamFilter{
+		Rules: map[string]*ParamRule{
+			"age":  {Field: "age", Type: ParamInt, Op: ParamOpRange},
+			"name": {Field: "info.name", Op: ParamOpContains},
+			"tags": {Field: "tags", Op: ParamOpIn},
+		},
+		SortFields: []string{"age"},
+	}
+	values, _ := url.ParseQuery(`age_from=3&name=a.b&tags=x,y&role[$ne]=admin&sort=-age`)
+	q, err := pf.Parse(values)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$and": bson.A{
+		bson.M{"age": bson.M{"$gte": int64(3)}},
+		bson.M{"info.name": primitive.Regex{Pattern: `a\.b`, Options: "i"}},
+		bson.M{"tags": bson.M{"$in": bson.A{"x", "y"}}},
+	}}, q.Filter())
+
+	values, _ = url.ParseQuery(`age_to={"$gt":""}`)
+	_, err = pf.Parse(values)
+	assert.True(t, errors.Is(err, ErrInvalidParam))
+
+	values, _ = url.ParseQuery(`sort=password`)
+	_, err = pf.Parse(values)
+	assert.True(t, errors.Is(err, ErrInvalidParam))
+}
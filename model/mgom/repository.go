@@ -0,0 +1,138 @@
+package mgom
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/94peter/sterna/dao"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Repository is the typed version of the find methods of MgoDBModel, T is a
+// pointer to the doc struct, e.g. Repository[*User].
+type Repository[T dao.DocInter] interface {
+	Find(q bson.M, opts ...*options.FindOptions) ([]T, error)
+	// FindOne returns mongo.ErrNoDocuments when nothing matches.
+	FindOne(q bson.M, opts ...*options.FindOneOptions) (T, error)
+	FindByID(id interface{}) (T, error)
+	// Iterate 逐筆解碼並呼叫 exec，exec 回傳錯誤時停止
+	Iterate(q bson.M, exec func(T) error, opts ...*options.FindOptions) error
+	Page(q bson.M, limit, page int64, opts ...*options.FindOptions) ([]T, error)
+	Aggregate(pipeline mongo.Pipeline, opts ...*options.AggregateOptions) ([]T, error)
+	Count(q bson.M) (int64, error)
+	Model() MgoDBModel
+}
+
+func NewRepository[T dao.DocInter](model MgoDBModel) Repository[T] {
+	return &repositoryImpl[T]{
+		model: model,
+	}
+}
+
+type repositoryImpl[T dao.DocInter] struct {
+	model MgoDBModel
+}
+
+// newDoc allocates the struct T points to.
+func newDoc[T dao.DocInter]() T {
+	var d T
+	t := reflect.TypeOf(d)
+	if t != nil && t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface().(T)
+	}
+	return d
+}
+
+func (r *repositoryImpl[T]) Model() MgoDBModel {
+	return r.model
+}
+
+func (r *repositoryImpl[T]) collection() *mongo.Collection {
+	return r.model.GetCollection(newDoc[T]())
+}
+
+func (r *repositoryImpl[T]) Find(q bson.M, opts ...*options.FindOptions) ([]T, error) {
+	ctx := r.model.Context()
+	cur, err := r.collection().Find(ctx, r.model.Filter(newDoc[T](), q), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[T](ctx, cur)
+}
+
+func (r *repositoryImpl[T]) FindOne(q bson.M, opts ...*options.FindOneOptions) (T, error) {
+	d := newDoc[T]()
+	err := r.collection().FindOne(r.model.Context(), r.model.Filter(d, q), opts...).Decode(d)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return d, nil
+}
+
+func (r *repositoryImpl[T]) FindByID(id interface{}) (T, error) {
+	return r.FindOne(bson.M{"_id": id})
+}
+
+func (r *repositoryImpl[T]) Iterate(q bson.M, exec func(T) error, opts ...*options.FindOptions) error {
+	if exec == nil {
+		return errors.New("exec is nil")
+	}
+	ctx := r.model.Context()
+	cur, err := r.collection().Find(ctx, r.model.Filter(newDoc[T](), q), opts...)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		d := newDoc[T]()
+		if err = cur.Decode(d); err != nil {
+			return err
+		}
+		if err = exec(d); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (r *repositoryImpl[T]) Page(q bson.M, limit, page int64, opts ...*options.FindOptions) ([]T, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	if page <= 0 {
+		page = 1
+	}
+	opts = append(opts, options.Find().SetSkip(limit*(page-1)).SetLimit(limit))
+	return r.Find(q, opts...)
+}
+
+func (r *repositoryImpl[T]) Aggregate(pipeline mongo.Pipeline, opts ...*options.AggregateOptions) ([]T, error) {
+	ctx := r.model.Context()
+	cur, err := r.collection().Aggregate(ctx, pipeline, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[T](ctx, cur)
+}
+
+func (r *repositoryImpl[T]) Count(q bson.M) (int64, error) {
+	return r.model.CountDocuments(newDoc[T](), q)
+}
+
+func decodeAll[T dao.DocInter](ctx context.Context, cur *mongo.Cursor) ([]T, error) {
+	defer cur.Close(ctx)
+	result := []T{}
+	for cur.Next(ctx) {
+		d := newDoc[T]()
+		if err := cur.Decode(d); err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	return result, cur.Err()
+}
@@ -0,0 +1,28 @@
+package mgom
+
+import (
+	"testing"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type repoDoc struct {
+	dao.CommonDoc `bson:",inline"`
+	ID            string `bson:"_id"`
+}
+
+func (d *repoDoc) GetC() string                   { return "repo" }
+func (d *repoDoc) GetDoc() interface{}            { return d }
+func (d *repoDoc) GetID() interface{}             { return d.ID }
+func (d *repoDoc) SetCreator(u dao.LogUser)       {}
+func (d *repoDoc) GetIndexes() []mongo.IndexModel { return nil }
+
+func Test_NewDoc(t *testing.T) {
+	d := newDoc[*repoDoc]()
+	assert.NotNil(t, d)
+	assert.Equal(t, "repo", d.GetC())
+	d.ID = "a"
+	assert.NotSame(t, d, newDoc[*repoDoc]())
+}
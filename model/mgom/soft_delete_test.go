@@ -0,0 +1,41 @@
+package mgom
+
+import (
+	"testing"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type softDoc struct {
+	repoDoc        `bson:",inline"`
+	dao.SoftDelete `bson:",inline"`
+}
+
+func Test_SoftDeleteFilter(t *testing.T) {
+	mm := &mgoModelImpl{}
+	q := bson.M{"name": "a"}
+	assert.Equal(t, q, mm.Filter(&repoDoc{}, q))
+	assert.Equal(t, bson.M{"$and": bson.A{q, bson.M{dao.FieldDeletedAt: nil}}}, mm.Filter(&softDoc{}, q))
+	assert.Equal(t, bson.M{dao.FieldDeletedAt: nil}, mm.Filter(&softDoc{}, nil))
+	assert.Equal(t, q, mm.WithDeleted().Filter(&softDoc{}, q))
+}
+
+type softAggr struct {
+	softDoc `bson:",inline"`
+}
+
+func (a *softAggr) GetPipeline(q bson.M) mongo.Pipeline {
+	return mongo.Pipeline{{{Key: "$match", Value: q}}}
+}
+
+func Test_SoftDeletePipeline(t *testing.T) {
+	mm := &mgoModelImpl{}
+	q := bson.M{"name": "a"}
+	pl := mm.pipeline(&softAggr{}, q)
+	assert.Len(t, pl, 2)
+	assert.Equal(t, bson.D{{Key: "$match", Value: bson.M{dao.FieldDeletedAt: nil}}}, pl[0])
+	assert.Len(t, mm.WithDeleted().(*mgoModelImpl).pipeline(&softAggr{}, q), 1)
+}
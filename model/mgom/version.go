@@ -0,0 +1,55 @@
+package mgom
+
+import (
+	"github.com/94peter/sterna/dao"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const duplicateKeyCode = 11000
+
+// versionCond matches version v, the docs saved before the type embeds
+// dao.Versioned have no version field and are version 0.
+func versionCond(v int64) interface{} {
+	if v == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return v
+}
+
+// withVersion adds the version check to filter and the increment to update.
+func withVersion(vd dao.VersionDoc, filter bson.M, update bson.D) (bson.M, bson.D) {
+	filter[dao.FieldVersion] = versionCond(vd.GetVersion())
+	return filter, append(update, bson.E{Key: "$inc", Value: bson.M{dao.FieldVersion: 1}})
+}
+
+// docWithoutVersion returns the fields of d for $set, the version is left
+// to $inc.
+func docWithoutVersion(d dao.DocInter) (bson.M, error) {
+	b, err := bson.Marshal(d.GetDoc())
+	if err != nil {
+		return nil, err
+	}
+	m := bson.M{}
+	if err = bson.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	delete(m, dao.FieldVersion)
+	return m, nil
+}
+
+func conflictErr(d dao.DocInter, vd dao.VersionDoc) error {
+	return &dao.VersionConflictError{
+		Collection: d.GetC(),
+		ID:         d.GetID(),
+		Version:    vd.GetVersion(),
+	}
+}
+
+// isConflict reports whether d exists with another version.
+func (mm *mgoModelImpl) isConflict(d dao.DocInter, vd dao.VersionDoc) bool {
+	n, err := mm.db.Collection(d.GetC()).CountDocuments(mm.context(), bson.M{
+		"_id":  d.GetID(),
+		"$nor": bson.A{bson.M{dao.FieldVersion: versionCond(vd.GetVersion())}},
+	})
+	return err == nil && n > 0
+}
@@ -0,0 +1,87 @@
+package mgom
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/log"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type versionDoc struct {
+	repoDoc       `bson:",inline"`
+	dao.Versioned `bson:",inline"`
+	Name          string `bson:"name"`
+}
+
+func (d *versionDoc) GetDoc() interface{} { return d }
+
+func Test_WithVersion(t *testing.T) {
+	d := &versionDoc{Name: "a"}
+	d.ID = "1"
+	d.SetVersion(3)
+	filter, update := withVersion(d, bson.M{"_id": d.ID}, bson.D{{Key: "$set", Value: bson.M{"name": "b"}}})
+	assert.Equal(t, bson.M{"_id": "1", dao.FieldVersion: int64(3)}, filter)
+	assert.Equal(t, bson.E{Key: "$inc", Value: bson.M{dao.FieldVersion: 1}}, update[1])
+
+	// 尚未有版本欄位的 doc 視為版本 0
+	d.SetVersion(0)
+	filter, _ = withVersion(d, bson.M{"_id": d.ID}, nil)
+	assert.Equal(t, bson.M{"$in": bson.A{0, nil}}, filter[dao.FieldVersion])
+
+	m, err := docWithoutVersion(d)
+	assert.Nil(t, err)
+	_, ok := m[dao.FieldVersion]
+	assert.False(t, ok)
+	assert.Equal(t, "a", m["name"])
+
+	err = conflictErr(d, d)
+	assert.True(t, errors.Is(err, dao.ErrVersionConflict))
+	assert.Equal(t, 409, err.(*dao.VersionConflictError).GetStatus())
+}
+
+// Test_UpdateUnversioned needs a mongo server, e.g.
+// TEST_MONGO_URI=mongodb://localhost:27017 go test ./model/mgom
+func Test_UpdateUnversioned(t *testing.T) {
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI not set")
+	}
+	ctx := context.Background()
+	clt, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	assert.Nil(t, err)
+	defer clt.Disconnect(ctx)
+	db := clt.Database("sterna_test")
+	defer db.Drop(ctx)
+
+	// 加入 dao.Versioned 前存入的資料沒有 _v
+	_, err = db.Collection("repo").InsertOne(ctx, bson.M{"_id": "1", "name": "a"})
+	assert.Nil(t, err)
+
+	model := NewMgoModel(ctx, db, (&log.LoggerConf{}).NewLogger("test"))
+	d := &versionDoc{Name: "b"}
+	d.ID = "1"
+	n, err := model.UpdateOne(d, bson.D{{Key: "name", Value: "b"}}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, int64(1), d.GetVersion())
+
+	stale := &versionDoc{Name: "c"}
+	stale.ID = "1"
+	_, err = model.UpdateOne(stale, bson.D{{Key: "name", Value: "c"}}, nil)
+	assert.True(t, errors.Is(err, dao.ErrVersionConflict))
+
+	_, err = db.Collection("repo").InsertOne(ctx, bson.M{"_id": "2", "name": "a"})
+	assert.Nil(t, err)
+	d = &versionDoc{Name: "b"}
+	d.ID = "2"
+	_, err = model.Upsert(d, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), d.GetVersion())
+}
@@ -0,0 +1,308 @@
+package mgom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/event"
+	"github.com/94peter/sterna/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ResumeTokenCollection = "change_stream_token"
+
+	OpInsert  = "insert"
+	OpUpdate  = "update"
+	OpReplace = "replace"
+	OpDelete  = "delete"
+
+	historyLostCode   = 286
+	retryInterval     = time.Second
+	maxRetryInterval  = 5 * time.Minute
+	defaultMaxRetries = 5
+)
+
+// ChangeEvent is one change of a watched collection, Doc is the full
+// document decoded into the registered type, nil when deleted.
+type ChangeEvent struct {
+	Operation     string              `json:"operation"`
+	Collection    string              `json:"collection"`
+	DocID         interface{}         `json:"docId"`
+	Doc           dao.DocInter        `json:"doc,omitempty"`
+	UpdatedFields bson.M              `json:"updatedFields,omitempty"`
+	RemovedFields []string            `json:"removedFields,omitempty"`
+	ClusterTime   primitive.Timestamp `json:"clusterTime"`
+}
+
+type ChangeHandler func(e *ChangeEvent) error
+
+// DropHandler receives the change given up after the retries, raw is the
+// change stream event and err the last failure.
+type DropHandler func(c string, raw bson.Raw, err error)
+
+// NewEventChangeHandler passes the change as json to an event handler.
+func NewEventChangeHandler(h event.EventHandler) ChangeHandler {
+	return func(e *ChangeEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return h(data)
+	}
+}
+
+type Watcher interface {
+	// Watch registers the handler of the collection of d, the full
+	// documents are decoded into the type of d.
+	Watch(d dao.DocInter, handler ChangeHandler) Watcher
+	// SetMaxRetries sets how many times a failed change is retried before
+	// it is dropped, default 5.
+	SetMaxRetries(n int) Watcher
+	// OnDrop registers the hook of the dropped changes, e.g. to keep them in
+	// a dead letter collection. Dropped changes are always logged.
+	OnDrop(h DropHandler) Watcher
+	// Run watches the collections until ctx is done, it resumes from the
+	// saved token and reopens the stream after errors. A failed change is
+	// retried with backoff, the token moves on when the handler succeeds or
+	// the change is dropped after the max retries.
+	Run(ctx context.Context) error
+}
+
+// NewWatcher watches the collections of database, the resume tokens are
+// saved in it too. name identifies the tokens so each consumer keeps its
+// own position.
+func NewWatcher(database *mongo.Database, name string, l log.Logger) Watcher {
+	return &watcherImpl{
+		db:         database,
+		name:       name,
+		log:        l,
+		maxRetries: defaultMaxRetries,
+	}
+}
+
+type watchTarget struct {
+	doc     dao.DocInter
+	handler ChangeHandler
+
+	// 連續失敗的變更與次數
+	failToken string
+	failCount int
+}
+
+// giveUp counts the failure of the change, it returns true when the change
+// failed more than maxRetries times in a row.
+func (t *watchTarget) giveUp(token bson.Raw, maxRetries int) bool {
+	if t.failToken != token.String() {
+		t.failToken, t.failCount = token.String(), 0
+	}
+	t.failCount++
+	return t.failCount > maxRetries
+}
+
+type watcherImpl struct {
+	db         *mongo.Database
+	name       string
+	log        log.Logger
+	maxRetries int
+	onDrop     DropHandler
+	targets    []*watchTarget
+}
+
+type resumeToken struct {
+	ID        string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+type rawChange struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID interface{} `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+	ClusterTime primitive.Timestamp `bson:"clusterTime"`
+}
+
+func (w *watcherImpl) Watch(d dao.DocInter, handler ChangeHandler) Watcher {
+	w.targets = append(w.targets, &watchTarget{doc: d, handler: handler})
+	return w
+}
+
+func (w *watcherImpl) SetMaxRetries(n int) Watcher {
+	w.maxRetries = n
+	return w
+}
+
+func (w *watcherImpl) OnDrop(h DropHandler) Watcher {
+	w.onDrop = h
+	return w
+}
+
+func (w *watcherImpl) Run(ctx context.Context) error {
+	if len(w.targets) == 0 {
+		return errors.New("no collection to watch")
+	}
+	var wg sync.WaitGroup
+	for _, t := range w.targets {
+		wg.Add(1)
+		go func(t *watchTarget) {
+			defer wg.Done()
+			wait := retryInterval
+			for {
+				handled, err := w.watch(ctx, t)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					w.log.Err(fmt.Sprintf("watch %s: %s, retry in %s", t.doc.GetC(), err.Error(), wait))
+				}
+				// 有處理成功的變更時重新計算等待時間
+				if handled > 0 {
+					wait = retryInterval
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+				if wait *= 2; wait > maxRetryInterval {
+					wait = maxRetryInterval
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (w *watcherImpl) tokenID(c string) string {
+	return w.name + ":" + c
+}
+
+func (w *watcherImpl) tokens() *mongo.Collection {
+	return w.db.Collection(ResumeTokenCollection)
+}
+
+func (w *watcherImpl) loadToken(ctx context.Context, c string) (bson.Raw, error) {
+	t := &resumeToken{}
+	err := w.tokens().FindOne(ctx, bson.M{"_id": w.tokenID(c)}).Decode(t)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return t.Token, err
+}
+
+func (w *watcherImpl) saveToken(ctx context.Context, c string, token bson.Raw) error {
+	_, err := w.tokens().UpdateOne(ctx, bson.M{"_id": w.tokenID(c)},
+		bson.M{"$set": bson.M{"token": token, "updatedAt": time.Now()}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (w *watcherImpl) open(ctx context.Context, c string) (*mongo.ChangeStream, error) {
+	token, err := w.loadToken(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	coll := w.db.Collection(c)
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+	stream, err := coll.Watch(ctx, mongo.Pipeline{}, opts)
+	var ce mongo.CommandError
+	// token 已超出 oplog 範圍時從目前位置重新開始
+	if token != nil && errors.As(err, &ce) && ce.Code == historyLostCode {
+		w.log.Warn(fmt.Sprintf("watch %s: resume token lost, restart from now", c))
+		return coll.Watch(ctx, mongo.Pipeline{}, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	}
+	return stream, err
+}
+
+func (w *watcherImpl) handle(c string, t *watchTarget, raw bson.Raw) error {
+	e, err := decodeChange(c, raw, t.doc)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	if err = t.handler(e); err != nil {
+		return fmt.Errorf("handle %v: %w", e.DocID, err)
+	}
+	return nil
+}
+
+// watch handles the changes until the stream or a handler fails and
+// returns the number of handled changes. The token is saved after each
+// handled or dropped change, so a failed change is received again when
+// the stream reopens.
+func (w *watcherImpl) watch(ctx context.Context, t *watchTarget) (int, error) {
+	c := t.doc.GetC()
+	stream, err := w.open(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close(context.Background())
+	handled := 0
+	for stream.Next(ctx) {
+		token := stream.ResumeToken()
+		if err = w.handle(c, t, stream.Current); err != nil {
+			if !t.giveUp(token, w.maxRetries) {
+				return handled, err
+			}
+			w.log.Err(fmt.Sprintf("watch %s drop change after %d retries: %s, %s",
+				c, w.maxRetries, err.Error(), stream.Current.String()))
+			if w.onDrop != nil {
+				w.onDrop(c, stream.Current, err)
+			}
+		}
+		if err = w.saveToken(ctx, c, token); err != nil {
+			return handled, err
+		}
+		handled++
+	}
+	return handled, stream.Err()
+}
+
+func decodeChange(c string, raw bson.Raw, d dao.DocInter) (*ChangeEvent, error) {
+	rc := &rawChange{}
+	if err := bson.Unmarshal(raw, rc); err != nil {
+		return nil, err
+	}
+	e := &ChangeEvent{
+		Operation:     rc.OperationType,
+		Collection:    c,
+		DocID:         rc.DocumentKey.ID,
+		UpdatedFields: rc.UpdateDescription.UpdatedFields,
+		RemovedFields: rc.UpdateDescription.RemovedFields,
+		ClusterTime:   rc.ClusterTime,
+	}
+	if len(rc.FullDocument) == 0 {
+		return e, nil
+	}
+	docType := reflect.TypeOf(d)
+	if docType.Kind() == reflect.Ptr {
+		docType = docType.Elem()
+	}
+	doc, ok := reflect.New(docType).Interface().(dao.DocInter)
+	if !ok {
+		return nil, fmt.Errorf("%s is not dao.DocInter", docType)
+	}
+	if err := bson.Unmarshal(rc.FullDocument, doc); err != nil {
+		return nil, err
+	}
+	e.Doc = doc
+	return e, nil
+}
@@ -0,0 +1,42 @@
+package mgom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_DecodeChange(t *testing.T) {
+	raw, _ := bson.Marshal(bson.M{
+		"operationType": OpUpdate,
+		"documentKey":   bson.M{"_id": "1"},
+		"fullDocument":  bson.M{"_id": "1"},
+		"updateDescription": bson.M{
+			"updatedFields": bson.M{"name": "b"},
+			"removedFields": bson.A{"email"},
+		},
+	})
+	e, err := decodeChange("repo", raw, &repoDoc{})
+	assert.Nil(t, err)
+	assert.Equal(t, OpUpdate, e.Operation)
+	assert.Equal(t, "1", e.DocID)
+	assert.Equal(t, "1", e.Doc.(*repoDoc).ID)
+	assert.Equal(t, []string{"email"}, e.RemovedFields)
+
+	raw, _ = bson.Marshal(bson.M{"operationType": OpDelete, "documentKey": bson.M{"_id": "1"}})
+	e, err = decodeChange("repo", raw, &repoDoc{})
+	assert.Nil(t, err)
+	assert.Nil(t, e.Doc)
+}
+
+func Test_WatchGiveUp(t *testing.T) {
+	target := &watchTarget{}
+	a, _ := bson.Marshal(bson.M{"_data": "a"})
+	b, _ := bson.Marshal(bson.M{"_data": "b"})
+	assert.False(t, target.giveUp(a, 2))
+	assert.False(t, target.giveUp(a, 2))
+	assert.True(t, target.giveUp(a, 2))
+	// 不同的變更重新計算
+	assert.False(t, target.giveUp(b, 2))
+}
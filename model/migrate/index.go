@@ -0,0 +1,252 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/94peter/sterna/dao"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	IndexCreate = "create"
+	IndexDrop   = "drop"
+
+	defaultIndex = "_id_"
+)
+
+type IndexChange struct {
+	Collection string
+	Name       string
+	Action     string
+	Model      *mongo.IndexModel `json:"-"`
+}
+
+func (c *IndexChange) String() string {
+	return fmt.Sprintf("%s index %s.%s", c.Action, c.Collection, c.Name)
+}
+
+type existIndex struct {
+	Name               string      `bson:"name"`
+	Key                bson.D      `bson:"key"`
+	Unique             bool        `bson:"unique"`
+	Sparse             bool        `bson:"sparse"`
+	ExpireAfterSeconds *int32      `bson:"expireAfterSeconds"`
+	PartialFilter      interface{} `bson:"partialFilterExpression"`
+}
+
+// ownedIndexes is the record of the indexes created by ReconcileIndexes,
+// only these are dropped when they are no longer declared.
+type ownedIndexes struct {
+	ID    string   `bson:"_id"`
+	Names []string `bson:"names"`
+}
+
+func ownedID(c string) string {
+	return "indexes:" + c
+}
+
+func toKeys(keys interface{}) bson.D {
+	switch k := keys.(type) {
+	case bson.D:
+		return k
+	case bson.E:
+		return bson.D{k}
+	case bson.M:
+		return mapKeys(k)
+	case map[string]interface{}:
+		return mapKeys(k)
+	}
+	return nil
+}
+
+// map 無法保證順序，只適合單一欄位的 index
+func mapKeys(m map[string]interface{}) bson.D {
+	result := make(bson.D, 0, len(m))
+	for k, v := range m {
+		result = append(result, bson.E{Key: k, Value: v})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// indexName follows the default name generated by the server, e.g. a_1_b_-1.
+func indexName(im mongo.IndexModel) string {
+	if im.Options != nil && im.Options.Name != nil {
+		return *im.Options.Name
+	}
+	var parts []string
+	for _, e := range toKeys(im.Keys) {
+		parts = append(parts, fmt.Sprintf("%s_%v", e.Key, e.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+func sameKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		// 數字型別可能不同 (int32/int64/float64)，以字串比較
+		if a[i].Key != b[i].Key || fmt.Sprint(a[i].Value) != fmt.Sprint(b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize 轉為 bson.M 後輸出，忽略 key 順序與數字型別
+func normalize(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	m := bson.M{}
+	if err = bson.Unmarshal(b, &m); err != nil {
+		return fmt.Sprint(v)
+	}
+	return fmt.Sprint(m)
+}
+
+// sameOptions compares the options which change the index behavior.
+func sameOptions(ei existIndex, im mongo.IndexModel) bool {
+	opts := im.Options
+	if opts == nil {
+		opts = options.Index()
+	}
+	boolOf := func(b *bool) bool {
+		return b != nil && *b
+	}
+	if ei.Unique != boolOf(opts.Unique) || ei.Sparse != boolOf(opts.Sparse) {
+		return false
+	}
+	if (ei.ExpireAfterSeconds == nil) != (opts.ExpireAfterSeconds == nil) ||
+		(ei.ExpireAfterSeconds != nil && *ei.ExpireAfterSeconds != *opts.ExpireAfterSeconds) {
+		return false
+	}
+	return normalize(ei.PartialFilter) == normalize(opts.PartialFilterExpression)
+}
+
+// diffIndexes returns the drops first then the creates needed to turn the
+// existing indexes into the desired ones. Indexes with the same name but
+// different keys or options are dropped and created again, indexes not
+// declared are only dropped when they are owned, i.e. created by
+// ReconcileIndexes, indexes created by hand or other services are kept.
+func diffIndexes(c string, exist []existIndex, desired []mongo.IndexModel, owned []string) []*IndexChange {
+	isOwned := make(map[string]bool, len(owned))
+	for _, name := range owned {
+		isOwned[name] = true
+	}
+	want := make(map[string]mongo.IndexModel, len(desired))
+	for _, im := range desired {
+		want[indexName(im)] = im
+	}
+	var drops, creates []*IndexChange
+	have := make(map[string]bool, len(exist))
+	for _, ei := range exist {
+		if ei.Name == defaultIndex {
+			continue
+		}
+		im, ok := want[ei.Name]
+		if ok && sameKeys(ei.Key, toKeys(im.Keys)) && sameOptions(ei, im) {
+			have[ei.Name] = true
+			continue
+		}
+		if !ok && !isOwned[ei.Name] {
+			continue
+		}
+		drops = append(drops, &IndexChange{Collection: c, Name: ei.Name, Action: IndexDrop})
+	}
+	for _, im := range desired {
+		name := indexName(im)
+		if have[name] {
+			continue
+		}
+		model := im
+		creates = append(creates, &IndexChange{Collection: c, Name: name, Action: IndexCreate, Model: &model})
+	}
+	return append(drops, creates...)
+}
+
+func (m *migratorImpl) listIndexes(ctx context.Context, c string) ([]existIndex, error) {
+	cur, err := m.db.Collection(c).Indexes().List(ctx)
+	if err != nil {
+		// 集合不存在時視為沒有 index
+		if ce, ok := err.(mongo.CommandError); ok && ce.Name == "NamespaceNotFound" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var result []existIndex
+	err = cur.All(ctx, &result)
+	return result, err
+}
+
+func (m *migratorImpl) ownedIndexes(ctx context.Context, c string) ([]string, error) {
+	o := &ownedIndexes{}
+	err := m.collection().FindOne(ctx, bson.M{"_id": ownedID(c)}).Decode(o)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return o.Names, err
+}
+
+func (m *migratorImpl) setOwned(ctx context.Context, change *IndexChange) error {
+	op := "$addToSet"
+	if change.Action == IndexDrop {
+		op = "$pull"
+	}
+	_, err := m.collection().UpdateOne(ctx, bson.M{"_id": ownedID(change.Collection)},
+		bson.M{op: bson.M{"names": change.Name}}, options.Update().SetUpsert(true))
+	return err
+}
+
+// ReconcileIndexes compares GetIndexes of the docs with the indexes in the
+// database and creates or drops the difference, in dry run mode it only
+// returns the changes. The created indexes are recorded in the migrations
+// collection, see diffIndexes.
+func (m *migratorImpl) ReconcileIndexes(ctx context.Context, docs ...dao.DocInter) ([]*IndexChange, error) {
+	var result []*IndexChange
+	for _, d := range docs {
+		exist, err := m.listIndexes(ctx, d.GetC())
+		if err != nil {
+			return result, err
+		}
+		owned, err := m.ownedIndexes(ctx, d.GetC())
+		if err != nil {
+			return result, err
+		}
+		changes := diffIndexes(d.GetC(), exist, d.GetIndexes(), owned)
+		if m.dryRun {
+			result = append(result, changes...)
+			continue
+		}
+		indexes := m.db.Collection(d.GetC()).Indexes()
+		for _, c := range changes {
+			m.info(c.String())
+			if c.Action == IndexDrop {
+				_, err = indexes.DropOne(ctx, c.Name)
+			} else {
+				_, err = indexes.CreateOne(ctx, *c.Model)
+			}
+			if err != nil {
+				return result, fmt.Errorf("%s fail: %w", c, err)
+			}
+			if err = m.setOwned(ctx, c); err != nil {
+				return result, err
+			}
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,379 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/94peter/sterna/dao"
+	"github.com/94peter/sterna/log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CollectionName = "migrations"
+
+	lockID     = "lock"
+	lockExpire = 10 * time.Minute
+	lockRenew  = lockExpire / 5
+)
+
+var (
+	ErrLocked       = errors.New("migration is running by another process")
+	ErrLockLost     = errors.New("migration lock lost")
+	ErrIrreversible = errors.New("migration can not be reverted")
+	ErrUnknown      = errors.New("applied migration not registered")
+)
+
+// Migration 版本號由小到大執行，Down 為 nil 時無法回復
+type Migration struct {
+	Version     int64
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+// Applied is the record saved in the migrations collection.
+type Applied struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+type Status struct {
+	Version     int64
+	Description string
+	AppliedAt   *time.Time
+}
+
+type Migrator interface {
+	Register(m ...*Migration) Migrator
+	// Indexes sets the docs whose indexes are reconciled after Up.
+	Indexes(docs ...dao.DocInter) Migrator
+	// DryRun 只回報會執行的項目，不修改資料庫
+	DryRun(b bool) Migrator
+	// Up applies the pending migrations up to target, 0 means the latest,
+	// then reconciles the indexes. It returns the migrations applied.
+	Up(ctx context.Context, target int64) ([]*Migration, []*IndexChange, error)
+	// Down reverts the applied migrations newer than target in reverse order.
+	Down(ctx context.Context, target int64) ([]*Migration, error)
+	Status(ctx context.Context) ([]*Status, error)
+	ReconcileIndexes(ctx context.Context, docs ...dao.DocInter) ([]*IndexChange, error)
+}
+
+func NewMigrator(db *mongo.Database, l log.Logger) Migrator {
+	host, _ := os.Hostname()
+	return &migratorImpl{
+		db:       db,
+		log:      l,
+		versions: make(map[int64]*Migration),
+		owner:    fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano()),
+	}
+}
+
+type migratorImpl struct {
+	db       *mongo.Database
+	log      log.Logger
+	lock     sync.Mutex
+	versions map[int64]*Migration
+	docs     []dao.DocInter
+	dryRun   bool
+	owner    string
+}
+
+func (m *migratorImpl) Register(list ...*Migration) Migrator {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, mi := range list {
+		if _, ok := m.versions[mi.Version]; ok {
+			panic(fmt.Sprintf("migration version %d duplicated", mi.Version))
+		}
+		if mi.Up == nil {
+			panic(fmt.Sprintf("migration version %d has no up", mi.Version))
+		}
+		m.versions[mi.Version] = mi
+	}
+	return m
+}
+
+func (m *migratorImpl) Indexes(docs ...dao.DocInter) Migrator {
+	m.docs = append(m.docs, docs...)
+	return m
+}
+
+func (m *migratorImpl) DryRun(b bool) Migrator {
+	m.dryRun = b
+	return m
+}
+
+func (m *migratorImpl) collection() *mongo.Collection {
+	return m.db.Collection(CollectionName)
+}
+
+func (m *migratorImpl) info(msg string) {
+	if m.log != nil {
+		m.log.Info(msg)
+	}
+}
+
+func (m *migratorImpl) warn(msg string) {
+	if m.log != nil {
+		m.log.Warn(msg)
+	}
+}
+
+// acquire 以 upsert 搶鎖，過期的鎖可被取代
+func (m *migratorImpl) acquire(ctx context.Context) (time.Time, error) {
+	now := time.Now()
+	expireAt := now.Add(lockExpire)
+	_, err := m.collection().UpdateOne(ctx,
+		bson.M{"_id": lockID, "expireAt": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": m.owner, "expireAt": expireAt}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return expireAt, ErrLocked
+	}
+	return expireAt, err
+}
+
+// renew extends the lock, it returns ErrLockLost when the lock is taken.
+func (m *migratorImpl) renew(ctx context.Context, expireAt time.Time) error {
+	result, err := m.collection().UpdateOne(ctx,
+		bson.M{"_id": lockID, "owner": m.owner},
+		bson.M{"$set": bson.M{"expireAt": expireAt}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// hold acquires the lock and renews it until unlock is called. The returned
+// ctx is canceled once the lock is lost, so the running migration fails
+// instead of running beside another process, unlock then returns
+// ErrLockLost.
+func (m *migratorImpl) hold(ctx context.Context) (context.Context, func(err error) error, error) {
+	expireAt, err := m.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var lost int32
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockRenew)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			next := time.Now().Add(lockExpire)
+			err := m.renew(ctx, next)
+			if err == nil {
+				expireAt = next
+				continue
+			}
+			// 網路錯誤時在鎖過期前重試
+			if err != ErrLockLost && time.Now().Add(lockRenew).Before(expireAt) {
+				m.warn("renew migration lock fail: " + err.Error())
+				continue
+			}
+			atomic.StoreInt32(&lost, 1)
+			cancel()
+			return
+		}
+	}()
+	return ctx, func(err error) error {
+		close(done)
+		cancel()
+		if atomic.LoadInt32(&lost) == 1 {
+			if err == nil {
+				return ErrLockLost
+			}
+			return fmt.Errorf("%w: %v", ErrLockLost, err)
+		}
+		m.release()
+		return err
+	}, nil
+}
+
+func (m *migratorImpl) release() {
+	_, err := m.collection().DeleteOne(context.Background(), bson.M{"_id": lockID, "owner": m.owner})
+	if err != nil && m.log != nil {
+		m.log.Err("release migration lock fail: " + err.Error())
+	}
+}
+
+func (m *migratorImpl) applied(ctx context.Context) (map[int64]*Applied, error) {
+	cur, err := m.collection().Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	var list []*Applied
+	if err = cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	result := make(map[int64]*Applied, len(list))
+	for _, a := range list {
+		result[a.Version] = a
+	}
+	return result, nil
+}
+
+func (m *migratorImpl) sorted() []*Migration {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	list := make([]*Migration, 0, len(m.versions))
+	for _, mi := range m.versions {
+		list = append(list, mi)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list
+}
+
+// pending returns the migrations not applied yet with version <= target,
+// 0 means no limit.
+func pending(list []*Migration, applied map[int64]*Applied, target int64) []*Migration {
+	var result []*Migration
+	for _, mi := range list {
+		if target > 0 && mi.Version > target {
+			break
+		}
+		if _, ok := applied[mi.Version]; !ok {
+			result = append(result, mi)
+		}
+	}
+	return result
+}
+
+// revertible returns the applied migrations with version > target from the
+// newest one.
+func revertible(list []*Migration, applied map[int64]*Applied, target int64) ([]*Migration, error) {
+	byVersion := make(map[int64]*Migration, len(list))
+	for _, mi := range list {
+		byVersion[mi.Version] = mi
+	}
+	var versions []int64
+	for v := range applied {
+		if v > target {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+	result := make([]*Migration, 0, len(versions))
+	for _, v := range versions {
+		mi, ok := byVersion[v]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", ErrUnknown, v)
+		}
+		if mi.Down == nil {
+			return nil, fmt.Errorf("%w: %d", ErrIrreversible, v)
+		}
+		result = append(result, mi)
+	}
+	return result, nil
+}
+
+func (m *migratorImpl) Up(ctx context.Context, target int64) (done []*Migration, changes []*IndexChange, err error) {
+	if !m.dryRun {
+		var unlock func(error) error
+		if ctx, unlock, err = m.hold(ctx); err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			err = unlock(err)
+		}()
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	list := pending(m.sorted(), applied, target)
+	if m.dryRun {
+		changes, err = m.ReconcileIndexes(ctx, m.docs...)
+		return list, changes, err
+	}
+	for _, mi := range list {
+		m.info(fmt.Sprintf("migrate up %d: %s", mi.Version, mi.Description))
+		if err = mi.Up(ctx, m.db); err != nil {
+			return done, nil, fmt.Errorf("migrate up %d fail: %w", mi.Version, err)
+		}
+		_, err = m.collection().InsertOne(ctx, &Applied{
+			Version:     mi.Version,
+			Description: mi.Description,
+			AppliedAt:   time.Now(),
+		})
+		if err != nil {
+			return done, nil, err
+		}
+		done = append(done, mi)
+	}
+	changes, err = m.ReconcileIndexes(ctx, m.docs...)
+	return done, changes, err
+}
+
+func (m *migratorImpl) Down(ctx context.Context, target int64) (done []*Migration, err error) {
+	if !m.dryRun {
+		var unlock func(error) error
+		if ctx, unlock, err = m.hold(ctx); err != nil {
+			return nil, err
+		}
+		defer func() {
+			err = unlock(err)
+		}()
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list, err := revertible(m.sorted(), applied, target)
+	if err != nil || m.dryRun {
+		return list, err
+	}
+	for _, mi := range list {
+		m.info(fmt.Sprintf("migrate down %d: %s", mi.Version, mi.Description))
+		if err = mi.Down(ctx, m.db); err != nil {
+			return done, fmt.Errorf("migrate down %d fail: %w", mi.Version, err)
+		}
+		if _, err = m.collection().DeleteOne(ctx, bson.M{"_id": mi.Version}); err != nil {
+			return done, err
+		}
+		done = append(done, mi)
+	}
+	return done, nil
+}
+
+func (m *migratorImpl) Status(ctx context.Context) ([]*Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result []*Status
+	for _, mi := range m.sorted() {
+		s := &Status{Version: mi.Version, Description: mi.Description}
+		if a, ok := applied[mi.Version]; ok {
+			at := a.AppliedAt
+			s.AppliedAt = &at
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func noop(context.Context, *mongo.Database) error { return nil }
+
+func Test_PendingAndRevertible(t *testing.T) {
+	list := []*Migration{
+		{Version: 1, Up: noop, Down: noop},
+		{Version: 2, Up: noop},
+		{Version: 3, Up: noop, Down: noop},
+	}
+	applied := map[int64]*Applied{1: {Version: 1}}
+	p := pending(list, applied, 0)
+	assert.Len(t, p, 2)
+	assert.Equal(t, int64(2), p[0].Version)
+	assert.Len(t, pending(list, applied, 2), 1)
+
+	applied[2] = &Applied{Version: 2}
+	applied[3] = &Applied{Version: 3}
+	r, err := revertible(list, applied, 2)
+	assert.Nil(t, err)
+	assert.Len(t, r, 1)
+	_, err = revertible(list, applied, 1)
+	assert.True(t, errors.Is(err, ErrIrreversible))
+}
+
+func Test_DiffIndexes(t *testing.T) {
+	ttl := int32(3600)
+	exist := []existIndex{
+		{Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+		{Name: "account_1", Key: bson.D{{Key: "account", Value: int32(1)}}},
+		{Name: "email_1", Key: bson.D{{Key: "email", Value: int32(1)}}},
+		{Name: "old_1", Key: bson.D{{Key: "old", Value: int32(1)}}},
+		{Name: "manual_1", Key: bson.D{{Key: "manual", Value: int32(1)}}},
+		{Name: "at_1", Key: bson.D{{Key: "at", Value: int32(1)}}, ExpireAfterSeconds: &ttl},
+		{Name: "state_1", Key: bson.D{{Key: "state", Value: int32(1)}},
+			PartialFilter: bson.D{{Key: "state", Value: bson.D{{Key: "$exists", Value: true}}}}},
+	}
+	desired := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "account", Value: 1}}},
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "host", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(60)},
+		{Keys: bson.D{{Key: "state", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"state": bson.M{"$exists": true}})},
+	}
+	changes := diffIndexes("user", exist, desired, []string{"old_1"})
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.Action+" "+c.Name)
+	}
+	assert.Equal(t, []string{
+		"drop email_1", "drop old_1", "drop at_1",
+		"create email_1", "create host_1_at_-1", "create at_1",
+	}, got)
+}
@@ -10,7 +10,7 @@ import (
 
 func CreateIndexByDao(ctx context.Context, clt *elasticsearch.Client, dao SearchDao) error {
 	indexReq := esapi.IndicesCreateRequest{
-		Index: dao.Index(),
+		Index: IndexName(ctx, dao.Index()),
 		Body:  strings.NewReader(dao.GetMapping()),
 	}
 	_, err := indexReq.Do(ctx, clt)
@@ -22,7 +22,7 @@ func CreateIndexByDao(ctx context.Context, clt *elasticsearch.Client, dao Search
 
 func CreateIndex(ctx context.Context, clt *elasticsearch.Client, index string, mapping string) error {
 	indexReq := esapi.IndicesCreateRequest{
-		Index: index,
+		Index: IndexName(ctx, index),
 		Body:  strings.NewReader(mapping),
 	}
 	_, err := indexReq.Do(ctx, clt)
@@ -10,7 +10,7 @@ import (
 
 func IsIndexExist(ctx context.Context, clt *elasticsearch.Client, index string) (bool, error) {
 	existsReq := esapi.IndicesExistsRequest{
-		Index: []string{index},
+		Index: []string{IndexName(ctx, index)},
 	}
 	resp, err := existsReq.Do(ctx, clt)
 	if err != nil {
@@ -21,7 +21,7 @@ func AddDocument(ctx context.Context, clt *elasticsearch.Client, dao SearchDao)
 		return err
 	}
 	indexReqA := esapi.IndexRequest{
-		Index:      dao.Index(),
+		Index:      IndexName(ctx, dao.Index()),
 		DocumentID: dao.Id(),
 		Body:       body,
 	}
@@ -36,10 +36,10 @@ func AddDocument(ctx context.Context, clt *elasticsearch.Client, dao SearchDao)
 }
 func BulkAddDocumentWithIndex(ctx context.Context, clt *elasticsearch.Client, index string, dao []SearchDao) error {
 	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Index:         index,            // The default index name
-		Client:        clt,              // The Elasticsearch client
-		NumWorkers:    2,                // The number of worker goroutines
-		FlushInterval: 30 * time.Second, // The periodic flush interval
+		Index:         IndexName(ctx, index), // The default index name
+		Client:        clt,                   // The Elasticsearch client
+		NumWorkers:    2,                     // The number of worker goroutines
+		FlushInterval: 30 * time.Second,      // The periodic flush interval
 	})
 	if err != nil {
 		return err
@@ -73,9 +73,9 @@ func BulkAddDocumentWithIndex(ctx context.Context, clt *elasticsearch.Client, in
 
 func BulkAddDocument(ctx context.Context, clt *elasticsearch.Client, dao []SearchDao) error {
 	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Index:      dao[0].Index(), // The default index name
-		Client:     clt,            // The Elasticsearch client
-		NumWorkers: 2,              // The number of worker goroutines
+		Index:      IndexName(ctx, dao[0].Index()), // The default index name
+		Client:     clt,                            // The Elasticsearch client
+		NumWorkers: 2,                              // The number of worker goroutines
 
 		FlushInterval: 30 * time.Second, // The periodic flush interval
 	})
@@ -111,7 +111,7 @@ func BulkAddDocument(ctx context.Context, clt *elasticsearch.Client, dao []Searc
 
 func DeleteDocument(ctx context.Context, clt *elasticsearch.Client, dao SearchDao) error {
 	deleteReq := esapi.DeleteRequest{
-		Index:      dao.Index(),
+		Index:      IndexName(ctx, dao.Index()),
 		DocumentID: dao.Id(),
 	}
 	resp, err := deleteReq.Do(ctx, clt)
@@ -135,7 +135,7 @@ func UpdateDucument(ctx context.Context, clt *elasticsearch.Client, dao SearchDa
 	newBody := bytes.Replace(
 		bodyTpl, []byte("%s"), buf.Bytes(), 1)
 	updateReq := esapi.UpdateRequest{
-		Index:      dao.Index(),
+		Index:      IndexName(ctx, dao.Index()),
 		DocumentID: dao.Id(),
 		Body:       bytes.NewReader(newBody),
 	}
@@ -0,0 +1,26 @@
+package search
+
+import (
+	"context"
+	"strings"
+
+	"github.com/94peter/sterna/util"
+)
+
+const CtxIndexPrefixKey = util.CtxKey("ctxEsIndexPrefix")
+
+// WithIndexPrefix returns ctx whose search functions use the indexes under
+// prefix, it is set by the tenant middleware.
+func WithIndexPrefix(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, CtxIndexPrefixKey, prefix)
+}
+
+// IndexName returns index under the prefix of ctx, an index which already
+// has the prefix is kept.
+func IndexName(ctx context.Context, index string) string {
+	prefix, _ := ctx.Value(CtxIndexPrefixKey).(string)
+	if prefix == "" || strings.HasPrefix(index, prefix) {
+		return index
+	}
+	return prefix + index
+}
@@ -41,7 +41,7 @@ func Search(ctx context.Context, clt *elasticsearch.Client, dao SearchDao, q Q)
 	}
 	res, err := clt.Search(
 		clt.Search.WithContext(ctx),
-		clt.Search.WithIndex(dao.Index()),
+		clt.Search.WithIndex(IndexName(ctx, dao.Index())),
 		clt.Search.WithBody(&buf),
 		clt.Search.WithTrackTotalHits(true),
 		clt.Search.WithPretty(),
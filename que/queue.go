@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/94peter/sterna/auth"
 	machinery "github.com/RichardKnop/machinery/v1"
 	amqpConf "github.com/RichardKnop/machinery/v1/config"
 	"github.com/RichardKnop/machinery/v1/tasks"
@@ -84,3 +85,15 @@ type Worker interface {
 	Register(tasks map[string]interface{})
 	Enable() bool
 }
+
+const userArgName = "reqUser"
+
+// NewUserArg encodes u as a task arg, the task function receives it as a
+// string and restores it with auth.DecodeReqUser.
+func NewUserArg(u auth.ReqUser) (tasks.Arg, error) {
+	s, err := auth.EncodeReqUser(u)
+	if err != nil {
+		return tasks.Arg{}, err
+	}
+	return tasks.Arg{Name: userArgName, Type: "string", Value: s}, nil
+}
@@ -0,0 +1,180 @@
+package tenant
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	yaml "gopkg.in/yaml.v3"
+)
+
+var ErrTenantNotFound = errors.New("tenant not found")
+
+const defaultTtl = 10 * time.Minute
+
+// Loader loads the config of the tenant id.
+type Loader func(id string) (*Tenant, error)
+
+func NewStaticLoader(tenants map[string]*Tenant) Loader {
+	return func(id string) (*Tenant, error) {
+		t, ok := tenants[id]
+		if !ok || t == nil {
+			return nil, ErrTenantNotFound
+		}
+		// 複製一份，不修改呼叫端的 map
+		result := *t
+		if result.ID == "" {
+			result.ID = id
+		}
+		return &result, nil
+	}
+}
+
+// NewUriLoader loads the tenant yaml from fmt.Sprintf(uriTpl, id).
+func NewUriLoader(uriTpl string) Loader {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(id string) (*Tenant, error) {
+		resp, err := client.Get(fmt.Sprintf(uriTpl, id))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrTenantNotFound
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("load tenant [%s] fail: %s", id, string(body))
+		}
+		t := &Tenant{}
+		if err = yaml.Unmarshal(body, t); err != nil {
+			return nil, err
+		}
+		if t.ID == "" {
+			t.ID = id
+		}
+		return t, nil
+	}
+}
+
+// Store caches the tenant configs loaded by the Loader.
+type Store interface {
+	Get(id string) (*Tenant, error)
+	// Refresh reloads the tenant config and replaces the cached one.
+	Refresh(id string) (*Tenant, error)
+	Invalidate(id string)
+	Clear()
+}
+
+func NewStore(loader Loader, ttl time.Duration) Store {
+	if ttl <= 0 {
+		ttl = defaultTtl
+	}
+	return &storeImpl{
+		loader: loader,
+		ttl:    ttl,
+		cache:  make(map[string]*cacheItem),
+	}
+}
+
+type cacheItem struct {
+	tenant  *Tenant
+	expired time.Time
+}
+
+type storeImpl struct {
+	loader Loader
+	ttl    time.Duration
+	group  singleflight.Group
+
+	lock  sync.RWMutex
+	cache map[string]*cacheItem
+}
+
+func (s *storeImpl) Get(id string) (*Tenant, error) {
+	if id == "" {
+		return nil, ErrTenantNotFound
+	}
+	s.lock.RLock()
+	item, ok := s.cache[id]
+	s.lock.RUnlock()
+	if ok && time.Now().Before(item.expired) {
+		return item.tenant, nil
+	}
+	return s.Refresh(id)
+}
+
+// Refresh shares one load between the concurrent calls of the same id.
+func (s *storeImpl) Refresh(id string) (*Tenant, error) {
+	v, err, _ := s.group.Do(id, func() (interface{}, error) {
+		t, err := s.loader(id)
+		if err != nil {
+			return nil, err
+		}
+		s.lock.Lock()
+		s.cache[id] = &cacheItem{
+			tenant:  t,
+			expired: time.Now().Add(s.ttl),
+		}
+		s.lock.Unlock()
+		return t, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Tenant), nil
+}
+
+func (s *storeImpl) Invalidate(id string) {
+	s.lock.Lock()
+	delete(s.cache, id)
+	s.lock.Unlock()
+}
+
+func (s *storeImpl) Clear() {
+	s.lock.Lock()
+	s.cache = make(map[string]*cacheItem)
+	s.lock.Unlock()
+}
+
+type TenantDI interface {
+	GetTenantStore() Store
+}
+
+// TenantConf loads tenants from the static list first, then from Uri.
+type TenantConf struct {
+	Uri     string             `yaml:"uri"`
+	Ttl     time.Duration      `yaml:"ttl"`
+	Tenants map[string]*Tenant `yaml:"tenants"`
+
+	once  sync.Once
+	store Store
+}
+
+func (tc *TenantConf) GetTenantStore() Store {
+	if tc == nil {
+		panic("tenant not set")
+	}
+	tc.once.Do(func() {
+		static := NewStaticLoader(tc.Tenants)
+		var remote Loader
+		if tc.Uri != "" {
+			remote = NewUriLoader(tc.Uri)
+		}
+		tc.store = NewStore(func(id string) (*Tenant, error) {
+			t, err := static(id)
+			if err == nil || remote == nil {
+				return t, err
+			}
+			return remote(id)
+		}, tc.Ttl)
+	})
+	return tc.store
+}
@@ -0,0 +1,35 @@
+package tenant
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StoreSingleLoad(t *testing.T) {
+	shared := map[string]*Tenant{"a": {Name: "A"}}
+	static := NewStaticLoader(shared)
+	var loads int32
+	s := NewStore(func(id string) (*Tenant, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		return static(id)
+	}, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tt, err := s.Get("a")
+			assert.Nil(t, err)
+			assert.Equal(t, "a", tt.ID)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	assert.Equal(t, "", shared["a"].ID)
+}
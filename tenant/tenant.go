@@ -0,0 +1,177 @@
+package tenant
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/94peter/sterna/auth"
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/gcp"
+	"github.com/94peter/sterna/model/search"
+	"github.com/94peter/sterna/util"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CtxTenantKey = util.CtxKey("tenant")
+
+	HeaderTenantKey = "X-Tenant"
+)
+
+// Tenant describes which resources a request is allowed to touch.
+type Tenant struct {
+	ID           string `yaml:"id"`
+	Name         string `yaml:"name"`
+	MongoDB      string `yaml:"mongoDB"`
+	RedisPrefix  string `yaml:"redisPrefix"`
+	EsPrefix     string `yaml:"esPrefix"`
+	BucketPrefix string `yaml:"bucketPrefix"`
+}
+
+// GetMongoDB returns the user db of the tenant, default to the tenant id.
+func (t *Tenant) GetMongoDB() string {
+	if t == nil {
+		return ""
+	}
+	if t.MongoDB != "" {
+		return t.MongoDB
+	}
+	return t.ID
+}
+
+func (t *Tenant) getRedisPrefix() string {
+	if t == nil {
+		return ""
+	}
+	if t.RedisPrefix != "" {
+		return t.RedisPrefix
+	}
+	return t.ID + ":"
+}
+
+func (t *Tenant) RedisKey(key string) string {
+	return t.getRedisPrefix() + key
+}
+
+func (t *Tenant) getEsPrefix() string {
+	if t == nil {
+		return ""
+	}
+	if t.EsPrefix != "" {
+		return t.EsPrefix
+	}
+	return t.ID + "_"
+}
+
+// EsIndex returns the elasticsearch index name of the tenant.
+func (t *Tenant) EsIndex(index string) string {
+	return t.getEsPrefix() + index
+}
+
+func (t *Tenant) getBucketPrefix() string {
+	if t == nil {
+		return ""
+	}
+	if t.BucketPrefix != "" {
+		return t.BucketPrefix
+	}
+	return t.ID
+}
+
+// BucketKey returns the storage object key of the tenant.
+func (t *Tenant) BucketKey(key string) string {
+	prefix := t.getBucketPrefix()
+	if prefix == "" {
+		return key
+	}
+	return util.StrAppend(strings.TrimSuffix(prefix, "/"), "/", strings.TrimPrefix(key, "/"))
+}
+
+func (t *Tenant) WrapRedis(clt db.RedisClient) db.RedisClient {
+	return db.NewPrefixRedisClient(clt, t.getRedisPrefix())
+}
+
+func (t *Tenant) WrapStorage(s gcp.Storage) gcp.Storage {
+	return gcp.NewPrefixStorage(s, t.getBucketPrefix())
+}
+
+// NewContext returns ctx carrying the tenant, the redis clients created by
+// db.RedisConf, the storage calls and the search functions with the ctx
+// use the prefixes of the tenant.
+func (t *Tenant) NewContext(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, CtxTenantKey, t)
+	ctx = db.WithRedisPrefix(ctx, t.getRedisPrefix())
+	ctx = gcp.WithStoragePrefix(ctx, t.getBucketPrefix())
+	return search.WithIndexPrefix(ctx, t.getEsPrefix())
+}
+
+func GetTenantByReq(req *http.Request) *Tenant {
+	return GetTenantByCtx(req.Context())
+}
+
+func GetTenantByCtx(ctx context.Context) *Tenant {
+	if t, ok := ctx.Value(CtxTenantKey).(*Tenant); ok {
+		return t
+	}
+	return nil
+}
+
+func GetTenantByGin(c *gin.Context) *Tenant {
+	t, ok := c.Get(string(CtxTenantKey))
+	if !ok {
+		return nil
+	}
+	return t.(*Tenant)
+}
+
+// Resolver returns the tenant id of the request, empty if not found.
+type Resolver func(c *gin.Context) string
+
+// FromClaim reads the tenant from the token user, the company id for
+// company tokens and the db claim for access tokens.
+func FromClaim() Resolver {
+	return func(c *gin.Context) string {
+		u := auth.GetUserByGin(c)
+		if u == nil {
+			return ""
+		}
+		if cu, ok := u.(auth.CompanyUser); ok {
+			return cu.GetCompID()
+		}
+		return u.GetDB()
+	}
+}
+
+// FromHost reads the tenant from the subdomain of baseDomain,
+// e.g. acme.example.com with baseDomain example.com resolves acme.
+func FromHost(baseDomain string) Resolver {
+	suffix := "." + strings.TrimPrefix(baseDomain, ".")
+	return func(c *gin.Context) string {
+		host := util.GetHost(c.Request)
+		if i := strings.Index(host, ":"); i > 0 {
+			host = host[:i]
+		}
+		if !strings.HasSuffix(host, suffix) {
+			return ""
+		}
+		sub := strings.TrimSuffix(host, suffix)
+		if strings.Contains(sub, ".") {
+			return ""
+		}
+		return sub
+	}
+}
+
+func FromHeader(key string) Resolver {
+	return func(c *gin.Context) string {
+		return c.GetHeader(key)
+	}
+}
+
+// FromPath reads the tenant from the route param, e.g. /t/:tenant/orders.
+func FromPath(param string) Resolver {
+	return func(c *gin.Context) string {
+		return c.Param(param)
+	}
+}
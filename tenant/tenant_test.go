@@ -0,0 +1,21 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/94peter/sterna/db"
+	"github.com/94peter/sterna/model/search"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TenantContext(t *testing.T) {
+	ten := &Tenant{ID: "acme"}
+	ctx := ten.NewContext(context.Background())
+	assert.Equal(t, ten, GetTenantByCtx(ctx))
+	assert.Equal(t, "acme:", ctx.Value(db.CtxRedisPrefixKey))
+	assert.Equal(t, "acme_order", search.IndexName(ctx, "order"))
+	// 已套用 EsIndex 的 index 不重複加上 prefix
+	assert.Equal(t, "acme_order", search.IndexName(ctx, ten.EsIndex("order")))
+	assert.Equal(t, "order", search.IndexName(context.Background(), "order"))
+}
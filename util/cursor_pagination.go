@@ -0,0 +1,137 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorPage is the rows of one keyset page returned by CursorSource, First
+// and Last are the keys of the first and the last row.
+type CursorPage struct {
+	Rows    []map[string]interface{}
+	First   string
+	Last    string
+	HasMore bool // 往查詢方向是否還有資料
+}
+
+// CursorSource reads rows after key, or before key when backward, the rows
+// are always in the sort order. An empty key starts from the beginning.
+type CursorSource interface {
+	Count() (int64, error)
+	CursorData(key string, backward bool, limit int64, format func(i interface{}) map[string]interface{}) (*CursorPage, error)
+}
+
+type CursorPagination interface {
+	Output(w io.Writer) error
+	GetRows() interface{}
+	GetNextCursor() string
+	GetPrevCursor() string
+}
+
+type cursorPaginationImpl struct {
+	Rows       []map[string]interface{} `json:"rows"`
+	NextCursor string                   `json:"nextCursor"`
+	PrevCursor string                   `json:"prevCursor"`
+	Total      *int64                   `json:"total,omitempty"`
+	Limit      int64                    `json:"limit"`
+}
+
+func (pi *cursorPaginationImpl) Output(w io.Writer) error {
+	return json.NewEncoder(w).Encode(pi)
+}
+
+func (pi *cursorPaginationImpl) GetRows() interface{} {
+	return pi.Rows
+}
+
+func (pi *cursorPaginationImpl) GetNextCursor() string {
+	return pi.NextCursor
+}
+
+func (pi *cursorPaginationImpl) GetPrevCursor() string {
+	return pi.PrevCursor
+}
+
+// cursor 記錄方向與 source 提供的 key，對外為不透明字串
+type cursor struct {
+	Backward bool   `json:"b,omitempty"`
+	Key      string `json:"k"`
+}
+
+func encodeCursor(key string, backward bool) string {
+	if key == "" {
+		return ""
+	}
+	b, _ := json.Marshal(&cursor{Backward: backward, Key: key})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	c := &cursor{}
+	if s == "" {
+		return c, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if err = json.Unmarshal(b, c); err != nil || c.Key == "" {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// NewCursorPagination returns the page at cursor, which is empty for the
+// first page or one of nextCursor and prevCursor of a previous page. The
+// total is counted only when withTotal is true.
+func NewCursorPagination(
+	source CursorSource,
+	cursorStr string,
+	limit int64,
+	withTotal bool,
+	format func(i interface{}) map[string]interface{},
+) (CursorPagination, error) {
+	c, err := decodeCursor(cursorStr)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > MaxLimit {
+		limit = 100
+	}
+	page, err := source.CursorData(c.Key, c.Backward, limit, format)
+	if err != nil {
+		return nil, err
+	}
+	result := &cursorPaginationImpl{
+		Rows:  page.Rows,
+		Limit: limit,
+	}
+	if result.Rows == nil {
+		result.Rows = []map[string]interface{}{}
+	}
+	if len(result.Rows) > 0 {
+		// 從 cursor 出發時，反方向一定還有資料
+		hasNext, hasPrev := page.HasMore, c.Key != ""
+		if c.Backward {
+			hasNext, hasPrev = true, page.HasMore
+		}
+		if hasNext {
+			result.NextCursor = encodeCursor(page.Last, false)
+		}
+		if hasPrev {
+			result.PrevCursor = encodeCursor(page.First, true)
+		}
+	}
+	if withTotal {
+		total, err := source.Count()
+		if err != nil {
+			return nil, err
+		}
+		result.Total = &total
+	}
+	return result, nil
+}
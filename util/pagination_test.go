@@ -0,0 +1,48 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCursorSource struct {
+	key      string
+	backward bool
+}
+
+func (s *fakeCursorSource) Count() (int64, error) {
+	return 3, nil
+}
+
+func (s *fakeCursorSource) CursorData(key string, backward bool, limit int64, format func(i interface{}) map[string]interface{}) (*CursorPage, error) {
+	s.key, s.backward = key, backward
+	return &CursorPage{
+		Rows:    []map[string]interface{}{{"id": 1}, {"id": 2}},
+		First:   "k1",
+		Last:    "k2",
+		HasMore: true,
+	}, nil
+}
+
+func Test_CursorPagination(t *testing.T) {
+	s := &fakeCursorSource{}
+	p, err := NewCursorPagination(s, "", 2, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "", p.GetPrevCursor())
+	assert.NotEqual(t, "", p.GetNextCursor())
+
+	p, err = NewCursorPagination(s, p.GetNextCursor(), 2, true, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "k2", s.key)
+	assert.False(t, s.backward)
+	assert.Equal(t, int64(3), *p.(*cursorPaginationImpl).Total)
+
+	_, err = NewCursorPagination(s, p.GetPrevCursor(), 2, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", s.key)
+	assert.True(t, s.backward)
+
+	_, err = NewCursorPagination(s, "!bad", 2, false, nil)
+	assert.Equal(t, ErrInvalidCursor, err)
+}